@@ -0,0 +1,152 @@
+package common_helpers
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// AuthMethodToken authenticates with a shared secret registered with the server
+	AuthMethodToken = "token"
+
+	// AuthMethodEd25519 authenticates by signing the session challenge with a key registered with the server
+	AuthMethodEd25519 = "ed25519"
+
+	// authChallengePrefix is prepended to the peer ID to form the challenge signed with ed25519 keys
+	authChallengePrefix = "P2P-CI-AUTH "
+)
+
+// AuthChallenge returns what a peer signs to authenticate its session.
+// It is bound to the peer ID assigned by the server, so a signature cannot be replayed on another session.
+func AuthChallenge(peerID string) []byte {
+	return []byte(authChallengePrefix + peerID)
+}
+
+// EncodePublicKey returns the base64 form public keys are registered with on the server
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// DecodePublicKey parses a public key registered with the server
+func DecodePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// GenerateAuthKey creates an ed25519 key, writes it as a PEM file readable by the owner only
+// and returns the public key to register with the server
+func GenerateAuthKey(keyFile string) (ed25519.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding key: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("error writing key: %w", err)
+	}
+	return public, nil
+}
+
+// LoadAuthKey reads an ed25519 key written by GenerateAuthKey
+func LoadAuthKey(keyFile string) (ed25519.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no key found in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key in %s is not an ed25519 key", keyFile)
+	}
+	return private, nil
+}
+
+// KeysFileEntry is a line of a keys file, registering the credential a peer authenticates with
+type KeysFileEntry struct {
+	Line       int
+	Name       string
+	Method     string
+	Credential string
+	Role       string
+}
+
+// ReadKeysFile reads a keys file, one credential per line:
+//
+//	<name> <token|ed25519> <token or base64 public key> [role]
+//
+// Blank lines and lines starting with # are ignored.
+func ReadKeysFile(path string) ([]KeysFileEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening keys file: %w", err)
+	}
+	defer file.Close()
+
+	entries := []KeysFileEntry{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("error in keys file line %d: expected <name> <method> <credential> [role], got %d fields", lineNumber, len(fields))
+		}
+		entry := KeysFileEntry{Line: lineNumber, Name: fields[0], Method: fields[1], Credential: fields[2]}
+		if len(fields) == 4 {
+			entry.Role = fields[3]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading keys file: %w", err)
+	}
+	return entries, nil
+}
+
+// LoadPublicKeys returns the ed25519 public keys of a keys file by name, token credentials are skipped
+func LoadPublicKeys(path string) (map[string]ed25519.PublicKey, error) {
+	entries, err := ReadKeysFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, entry := range entries {
+		if entry.Method != AuthMethodEd25519 {
+			continue
+		}
+		key, err := DecodePublicKey(entry.Credential)
+		if err != nil {
+			return nil, fmt.Errorf("error in keys file line %d: %w", entry.Line, err)
+		}
+		keys[entry.Name] = key
+	}
+	return keys, nil
+}
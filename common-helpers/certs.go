@@ -0,0 +1,144 @@
+package common_helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	// CAValidity and CertificateValidity are how long generated certificates stay valid
+	CAValidity          = 10 * 365 * 24 * time.Hour
+	CertificateValidity = 2 * 365 * 24 * time.Hour
+)
+
+// GenerateCA creates a self-signed CA and writes its certificate and key as PEM files
+func GenerateCA(commonName, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating CA key: %w", err)
+	}
+
+	template, err := certificateTemplate(commonName, CAValidity)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("error creating CA certificate: %w", err)
+	}
+	return writeKeyPair(der, key, certFile, keyFile)
+}
+
+// IssueCertificate creates a certificate signed by the CA for the server or a peer.
+// It is valid both for accepting and for making connections, hosts are added as IP or DNS names.
+func IssueCertificate(caCertFile, caKeyFile, commonName string, hosts []string, certFile, keyFile string) error {
+	caCert, caKey, err := loadKeyPair(caCertFile, caKeyFile)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating key: %w", err)
+	}
+
+	template, err := certificateTemplate(commonName, CertificateValidity)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("error creating certificate: %w", err)
+	}
+	return writeKeyPair(der, key, certFile, keyFile)
+}
+
+// certificateTemplate returns the fields shared by every generated certificate
+func certificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"P2P-CI"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// writeKeyPair writes a certificate and its private key as PEM files, the key readable by the owner only
+func writeKeyPair(der []byte, key *ecdsa.PrivateKey, certFile, keyFile string) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding key: %w", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("error writing certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("error writing key: %w", err)
+	}
+	return nil
+}
+
+// loadKeyPair reads a PEM certificate and private key written by writeKeyPair
+func loadKeyPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading key: %w", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no key found in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("key in %s cannot sign", keyFile)
+	}
+	return cert, signer, nil
+}
@@ -0,0 +1,154 @@
+// Package codec provides the wire encodings spoken between peers and the server.
+// Every encoding carries the same common_helpers.Frame so the handlers do not care
+// which one is on the wire.
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	common_helpers "P2P/common-helpers"
+)
+
+const (
+	// Names of the encodings selectable through configuration
+	JSONName = "json"
+	TextName = "text"
+)
+
+// Codec encodes and decodes protocol frames on a stream.
+// Only the head of a frame (type and header) goes through the codec, the body
+// follows it verbatim so large bodies can be streamed.
+type Codec interface {
+	// Name returns the configuration name of the encoding
+	Name() string
+	// WriteFrameHead writes the head of a frame whose body of bodyLength bytes follows
+	WriteFrameHead(w *bufio.Writer, frameType byte, header []byte, bodyLength int64) error
+	// ReadFrameHead reads the head of a frame and returns the length of the body that follows
+	ReadFrameHead(r *bufio.Reader) (common_helpers.Frame, int64, error)
+}
+
+var (
+	// JSON is the length-prefixed binary framing carrying JSON headers
+	JSON Codec = jsonCodec{}
+
+	// Text is the line-oriented P2P-CI/1.0 request/response format
+	Text Codec = textCodec{}
+)
+
+// ByName returns the codec registered under name
+func ByName(name string) (Codec, error) {
+	switch name {
+	case JSONName:
+		return JSON, nil
+	case TextName:
+		return Text, nil
+	default:
+		return nil, fmt.Errorf("unknown protocol encoding %q", name)
+	}
+}
+
+// Detect peeks at the first byte of the stream to find out which codec the remote side speaks.
+// Text messages start with an upper case method or version, framed messages with a small type byte.
+func Detect(r *bufio.Reader) (Codec, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] >= 'A' && b[0] <= 'Z' {
+		return Text, nil
+	}
+	return JSON, nil
+}
+
+// Conn is a network connection speaking a single codec.
+// WriteFrame may be called from several goroutines, reads are not synchronized.
+type Conn struct {
+	net.Conn
+	Codec Codec
+
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	writeMu sync.Mutex
+}
+
+// NewConn wraps conn so frames are exchanged using c
+func NewConn(conn net.Conn, c Codec) *Conn {
+	return &Conn{
+		Conn:   conn,
+		Codec:  c,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+// Accept wraps an accepted connection using the codec the remote side speaks.
+// It blocks until the first byte of the first message arrives.
+func Accept(conn net.Conn) (*Conn, error) {
+	c := NewConn(conn, JSON)
+	detected, err := Detect(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	c.Codec = detected
+	return c, nil
+}
+
+// ReadFrame reads a single frame from the connection, body included
+func (c *Conn) ReadFrame() (common_helpers.Frame, error) {
+	frame, bodyLength, err := c.ReadFrameHead()
+	if err != nil {
+		return common_helpers.Frame{}, err
+	}
+	if bodyLength > common_helpers.MaxFrameBodySize {
+		return common_helpers.Frame{}, fmt.Errorf("%w: body %d bytes", common_helpers.ErrFrameTooLarge, bodyLength)
+	}
+
+	frame.Body = make([]byte, bodyLength)
+	if _, err := io.ReadFull(c.reader, frame.Body); err != nil {
+		return common_helpers.Frame{}, fmt.Errorf("error reading frame body: %w", err)
+	}
+	return frame, nil
+}
+
+// ReadFrameHead reads the head of a frame and returns the body length.
+// The body must then be consumed through BodyReader before the next frame is read.
+func (c *Conn) ReadFrameHead() (common_helpers.Frame, int64, error) {
+	return c.Codec.ReadFrameHead(c.reader)
+}
+
+// BodyReader returns a reader over the bodyLength bytes of body following a frame head
+func (c *Conn) BodyReader(bodyLength int64) io.Reader {
+	return io.LimitReader(c.reader, bodyLength)
+}
+
+// WriteFrame writes a single frame to the connection
+func (c *Conn) WriteFrame(frameType byte, header []byte, body []byte) error {
+	if len(body) > common_helpers.MaxFrameBodySize {
+		return common_helpers.ErrFrameTooLarge
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.Codec.WriteFrameHead(c.writer, frameType, header, int64(len(body))); err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(body); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// WriteFrameHead writes and flushes the head of a frame.
+// The caller then writes exactly bodyLength bytes of body straight to the connection.
+func (c *Conn) WriteFrameHead(frameType byte, header []byte, bodyLength int64) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.Codec.WriteFrameHead(c.writer, frameType, header, bodyLength); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
@@ -0,0 +1,22 @@
+package codec
+
+import (
+	"bufio"
+
+	common_helpers "P2P/common-helpers"
+)
+
+// jsonCodec sends frames as type byte + length prefixes + JSON header + body
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSONName
+}
+
+func (jsonCodec) WriteFrameHead(w *bufio.Writer, frameType byte, header []byte, bodyLength int64) error {
+	return common_helpers.WriteFrameHead(w, frameType, header, bodyLength)
+}
+
+func (jsonCodec) ReadFrameHead(r *bufio.Reader) (common_helpers.Frame, int64, error) {
+	return common_helpers.ReadFrameHead(r)
+}
@@ -0,0 +1,543 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	common_helpers "P2P/common-helpers"
+)
+
+const (
+	// MaxTextLineLength is the longest line accepted by the text codec
+	MaxTextLineLength = 8 << 10
+
+	// MaxTextHeaders is the most header lines accepted in a single message
+	MaxTextHeaders = 64
+
+	// Targets following the method of a request line
+	targetRFC = "RFC"
+	targetAll = "ALL"
+)
+
+// ErrTextLineTooLong is returned when a line exceeds MaxTextLineLength
+var ErrTextLineTooLong = errors.New("text line exceeds maximum line length")
+
+// textHeader maps a header line on the wire to the JSON key of a struct field
+type textHeader struct {
+	name string
+	key  string
+}
+
+// textRequest describes how a request frame maps onto a P2P-CI method line and headers:
+//
+//	<method> <target> [<number>] <version>\r\n
+//	<header>: <value>\r\n
+//	\r\n
+type textRequest struct {
+	frameType  byte
+	method     string
+	target     string
+	numberKey  string
+	versionKey string
+	headers    []textHeader
+}
+
+var (
+	// requestIDHeader carries the ID a request is traced by, responses echo it
+	requestIDHeader = textHeader{"Request-ID", "Request_ID"}
+
+	// serverResponseHeaders lists the headers of a server response in wire order
+	serverResponseHeaders = []textHeader{requestIDHeader, {"Next-Cursor", "Next_Cursor"}}
+
+	// serverEntryHeaders lists the headers following the line of an RFC in a server response
+	serverEntryHeaders = []textHeader{{"Digest", "RFC_Digest"}, {"Manifest", "RFC_Manifest"}}
+
+	// serverEntryKeys are the fields carried on the line of an RFC itself
+	serverEntryKeys = []string{"RFC_Number", "RFC_Title", "Client_IP", "Client_Upload_Port"}
+
+	// textRequests lists every request frame that has a text form
+	textRequests = []textRequest{
+		{
+			frameType:  common_helpers.AddStructIndex,
+			method:     "ADD",
+			target:     targetRFC,
+			numberKey:  "RFC_Number",
+			versionKey: "Client_Application_Version",
+			headers:    []textHeader{{"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}, {"Title", "RFC_Title"}, {"Digest", "RFC_Digest"}, {"Manifest", "RFC_Manifest"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.RemoveStructIndex,
+			method:     "REMOVE",
+			target:     targetRFC,
+			numberKey:  "RFC_Number",
+			versionKey: "Client_Application_Version",
+			headers:    []textHeader{{"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}, {"Title", "RFC_Title"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.LookupStructIndex,
+			method:     "LOOKUP",
+			target:     targetRFC,
+			numberKey:  "RFC_Number",
+			versionKey: "Client_Application_Version",
+			headers:    []textHeader{{"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}, {"Title", "RFC_Title"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.ListStructIndex,
+			method:     "LIST",
+			target:     targetAll,
+			versionKey: "Client_Application_Version",
+			headers: []textHeader{{"Host", "Client_IP"}, {"Port", "Client_Upload_Port"},
+				{"Limit", "Limit"}, {"Cursor", "Cursor"}, {"Mode", "Mode"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.HeartbeatIndex,
+			method:     "HEARTBEAT",
+			target:     targetAll,
+			versionKey: "Client_Application_Version",
+			headers:    []textHeader{{"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}},
+		},
+		{
+			frameType:  common_helpers.AuthIndex,
+			method:     "AUTH",
+			target:     targetAll,
+			versionKey: "Client_Application_Version",
+			headers:    []textHeader{{"Name", "Name"}, {"Method", "Method"}, {"Credential", "Credential"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.SearchIndex,
+			method:     "SEARCH",
+			target:     targetAll,
+			versionKey: "Client_Application_Version",
+			headers: []textHeader{{"Number", "RFC_Number"}, {"From", "RFC_Number_From"}, {"To", "RFC_Number_To"},
+				{"Title", "RFC_Title"}, {"Match", "Title_Match"}, {"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.SubscribeIndex,
+			method:     "SUBSCRIBE",
+			target:     targetAll,
+			versionKey: "Client_Application_Version",
+			headers: []textHeader{{"Number", "RFC_Number"}, {"From", "RFC_Number_From"}, {"To", "RFC_Number_To"},
+				{"Title", "RFC_Title"}, {"Match", "Title_Match"}, {"Host", "Client_IP"}, {"Port", "Client_Upload_Port"}, requestIDHeader},
+		},
+		{
+			// Notifications are pushed by the server, they are written like a request so they cannot be taken for a response
+			frameType:  common_helpers.NotificationIndex,
+			method:     "NOTIFY",
+			target:     targetRFC,
+			numberKey:  "RFC_Number",
+			versionKey: "Server_Application_Version",
+			headers: []textHeader{{"Event", "Event"}, {"Title", "RFC_Title"}, {"Host", "Client_IP"}, {"Port", "Client_Upload_Port"},
+				{"Digest", "RFC_Digest"}, {"Manifest", "RFC_Manifest"}, requestIDHeader},
+		},
+		{
+			frameType:  common_helpers.PeerRequestIndex,
+			method:     "GET",
+			target:     targetRFC,
+			numberKey:  "RFCNumber",
+			versionKey: "Version",
+			headers:    []textHeader{{"Host", "PeerIP"}, {"OS", "PeerOS"}, {"Range-Start", "RangeStart"}, {"Range-Length", "RangeLength"}, requestIDHeader},
+		},
+	}
+
+	// peerResponseHeaders lists the headers of a peer response in wire order
+	peerResponseHeaders = []textHeader{
+		{"Date", "CurrentDateandTime"},
+		{"OS", "OS"},
+		{"Last-Modified", "LastModifiedDateandTime"},
+		{"Content-Length", "ContentLength"},
+		{"Content-Type", "ContentType"},
+		{"RFC-Title", "RFCTitle"},
+		{"Content-Digest", "ContentDigest"},
+		{"Content-Range", "ContentRange"},
+		requestIDHeader,
+	}
+)
+
+// textCodec speaks the line-oriented P2P-CI/1.0 format described in Sample_commands.txt.
+// Fields without a standard header are carried as extra headers named after their JSON key.
+type textCodec struct{}
+
+func (textCodec) Name() string {
+	return TextName
+}
+
+func (textCodec) WriteFrameHead(w *bufio.Writer, frameType byte, header []byte, bodyLength int64) error {
+	// Only peer responses carry a body, announced through Content-Length
+	if frameType == common_helpers.PeerResponseIndex {
+		return writePeerResponse(w, header, bodyLength)
+	}
+	if bodyLength != 0 {
+		return fmt.Errorf("message type %d cannot carry a body in the P2P-CI text form", frameType)
+	}
+	if frameType == common_helpers.ServerResponseIndex {
+		return writeServerResponse(w, header)
+	}
+
+	for _, request := range textRequests {
+		if request.frameType == frameType {
+			return writeRequest(w, request, header)
+		}
+	}
+	return fmt.Errorf("message type %d has no P2P-CI text form", frameType)
+}
+
+func (textCodec) ReadFrameHead(r *bufio.Reader) (common_helpers.Frame, int64, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return common_helpers.Frame{}, 0, err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return common_helpers.Frame{}, 0, fmt.Errorf("empty P2P-CI start line")
+	}
+
+	// Responses start with the protocol version, requests with the method
+	if strings.Contains(parts[0], "/") {
+		return readResponse(r, parts)
+	}
+
+	for _, request := range textRequests {
+		if request.method == parts[0] {
+			frame, err := readRequest(r, request, parts)
+			return frame, 0, err
+		}
+	}
+	return common_helpers.Frame{}, 0, fmt.Errorf("unknown P2P-CI method %q", parts[0])
+}
+
+// writeRequest writes a request frame as method line and headers
+func writeRequest(w *bufio.Writer, request textRequest, header []byte) error {
+	var fields map[string]any
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return fmt.Errorf("error decoding %s request: %w", request.method, err)
+	}
+
+	if request.numberKey != "" {
+		fmt.Fprintf(w, "%s %s %s %s\r\n", request.method, request.target,
+			fieldString(fields[request.numberKey]), fieldString(fields[request.versionKey]))
+	} else {
+		fmt.Fprintf(w, "%s %s %s\r\n", request.method, request.target, fieldString(fields[request.versionKey]))
+	}
+	delete(fields, request.numberKey)
+	delete(fields, request.versionKey)
+
+	if err := writeHeaders(w, request.headers, fields); err != nil {
+		return err
+	}
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+// readRequest parses the rest of a request whose method line has already been read
+func readRequest(r *bufio.Reader, request textRequest, parts []string) (common_helpers.Frame, error) {
+	fields := make(map[string]any)
+
+	expected := 3
+	if request.numberKey != "" {
+		expected = 4
+	}
+	if len(parts) != expected || parts[1] != request.target {
+		return common_helpers.Frame{}, fmt.Errorf("malformed %s request line", request.method)
+	}
+	if request.numberKey != "" {
+		fields[request.numberKey] = parts[2]
+	}
+	fields[request.versionKey] = parts[len(parts)-1]
+
+	if err := readHeaders(r, request.headers, fields); err != nil {
+		return common_helpers.Frame{}, err
+	}
+
+	header, err := json.Marshal(fields)
+	if err != nil {
+		return common_helpers.Frame{}, err
+	}
+	return common_helpers.Frame{Type: request.frameType, Header: header}, nil
+}
+
+// writeServerResponse writes a server response as status line, headers and one line per RFC,
+// each followed by the headers of that RFC:
+//
+//	<version> <code> <phrase>\r\n
+//	\r\n
+//	RFC <number> <title> <host> <port>\r\n
+//	Digest: <digest>\r\n
+//	\r\n
+func writeServerResponse(w *bufio.Writer, header []byte) error {
+	var response struct {
+		Header map[string]any
+		Data   []map[string]any
+	}
+	if err := json.Unmarshal(header, &response); err != nil {
+		return fmt.Errorf("error decoding server response: %w", err)
+	}
+
+	fmt.Fprintf(w, "%s %s %s\r\n", fieldString(response.Header["Server_Application_Version"]),
+		fieldString(response.Header["Response_Code"]), fieldString(response.Header["Response_Phrase"]))
+	delete(response.Header, "Server_Application_Version")
+	delete(response.Header, "Response_Code")
+	delete(response.Header, "Response_Phrase")
+
+	if err := writeHeaders(w, serverResponseHeaders, response.Header); err != nil {
+		return err
+	}
+	w.WriteString("\r\n")
+
+	for _, entry := range response.Data {
+		fmt.Fprintf(w, "RFC %s %s %s %s\r\n", fieldString(entry["RFC_Number"]), fieldString(entry["RFC_Title"]),
+			fieldString(entry["Client_IP"]), fieldString(entry["Client_Upload_Port"]))
+		for _, key := range serverEntryKeys {
+			delete(entry, key)
+		}
+		if err := writeHeaders(w, serverEntryHeaders, entry); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+// writePeerResponse writes a peer response as status line and headers, the RFC text follows
+func writePeerResponse(w *bufio.Writer, header []byte, bodyLength int64) error {
+	var fields map[string]any
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return fmt.Errorf("error decoding peer response: %w", err)
+	}
+
+	fmt.Fprintf(w, "%s %s %s\r\n", fieldString(fields["PeerApplicationVersion"]),
+		fieldString(fields["Status"]), fieldString(fields["Phrase"]))
+	delete(fields, "PeerApplicationVersion")
+	delete(fields, "Status")
+	delete(fields, "Phrase")
+
+	// The body length on the wire is always the length of the body actually sent
+	fields["ContentLength"] = strconv.FormatInt(bodyLength, 10)
+	if fieldString(fields["RFCTitle"]) == "" {
+		delete(fields, "RFCTitle")
+	}
+	if fieldString(fields["ContentDigest"]) == "" {
+		delete(fields, "ContentDigest")
+	}
+	if fieldString(fields["ContentRange"]) == "" {
+		delete(fields, "ContentRange")
+	}
+
+	if err := writeHeaders(w, peerResponseHeaders, fields); err != nil {
+		return err
+	}
+	_, err := w.WriteString("\r\n")
+	return err
+}
+
+// readResponse parses the rest of a response whose status line has already been read.
+// Peer responses carry a Content-Length header, server responses list RFCs instead.
+func readResponse(r *bufio.Reader, parts []string) (common_helpers.Frame, int64, error) {
+	if len(parts) < 3 {
+		return common_helpers.Frame{}, 0, fmt.Errorf("malformed P2P-CI status line")
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return common_helpers.Frame{}, 0, fmt.Errorf("malformed P2P-CI status code %q", parts[1])
+	}
+	phrase := strings.Join(parts[2:], " ")
+
+	fields := make(map[string]any)
+	if err := readHeaders(r, slices.Concat(peerResponseHeaders, serverResponseHeaders), fields); err != nil {
+		return common_helpers.Frame{}, 0, err
+	}
+
+	if contentLength, ok := fields["ContentLength"]; ok {
+		return readPeerResponseHead(parts[0], code, phrase, contentLength.(string), fields)
+	}
+
+	// Without a Content-Length the response came from the server
+	header := map[string]any{
+		"Server_Application_Version": parts[0],
+		"Response_Code":              code,
+		"Response_Phrase":            phrase,
+	}
+	for key, value := range fields {
+		header[key] = value
+	}
+
+	entries := []map[string]any{}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return common_helpers.Frame{}, 0, err
+		}
+		if line == "" {
+			break
+		}
+
+		// Lines other than RFC lines are headers of the RFC above them
+		entry := strings.Fields(line)
+		if len(entry) == 0 || entry[0] != targetRFC {
+			if len(entries) == 0 {
+				return common_helpers.Frame{}, 0, fmt.Errorf("RFC header %q before any RFC line", line)
+			}
+			if err := readHeader(line, serverEntryHeaders, entries[len(entries)-1]); err != nil {
+				return common_helpers.Frame{}, 0, err
+			}
+			continue
+		}
+		if len(entry) < 5 {
+			return common_helpers.Frame{}, 0, fmt.Errorf("malformed RFC line %q", line)
+		}
+		entries = append(entries, map[string]any{
+			"RFC_Number":         entry[1],
+			"RFC_Title":          strings.Join(entry[2:len(entry)-2], " "),
+			"Client_IP":          entry[len(entry)-2],
+			"Client_Upload_Port": entry[len(entry)-1],
+		})
+	}
+
+	b, err := json.Marshal(map[string]any{"Header": header, "Data": entries})
+	if err != nil {
+		return common_helpers.Frame{}, 0, err
+	}
+	return common_helpers.Frame{Type: common_helpers.ServerResponseIndex, Header: b}, 0, nil
+}
+
+// readPeerResponseHead builds the header of a peer response, the RFC text is left on the stream
+func readPeerResponseHead(version string, code int, phrase string, contentLength string, fields map[string]any) (common_helpers.Frame, int64, error) {
+	length, err := strconv.ParseInt(contentLength, 10, 64)
+	if err != nil || length < 0 {
+		return common_helpers.Frame{}, 0, fmt.Errorf("malformed Content-Length %q", contentLength)
+	}
+
+	fields["PeerApplicationVersion"] = version
+	fields["Status"] = code
+	fields["Phrase"] = phrase
+
+	header, err := json.Marshal(fields)
+	if err != nil {
+		return common_helpers.Frame{}, 0, err
+	}
+	return common_helpers.Frame{Type: common_helpers.PeerResponseIndex, Header: header}, length, nil
+}
+
+// writeHeaders writes the standard headers in order, followed by any remaining fields sorted by key
+func writeHeaders(w *bufio.Writer, headers []textHeader, fields map[string]any) error {
+	for _, h := range headers {
+		value, ok := fields[h.key]
+		if !ok {
+			continue
+		}
+		if err := writeHeader(w, h.name, fieldString(value)); err != nil {
+			return err
+		}
+		delete(fields, h.key)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fieldString(fields[key])
+		if value == "" {
+			continue
+		}
+		if err := writeHeader(w, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(w *bufio.Writer, name, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %s contains a line break", name)
+	}
+	_, err := fmt.Fprintf(w, "%s: %s\r\n", name, value)
+	return err
+}
+
+// readHeaders reads header lines up to the blank line, storing them in fields under their JSON key
+func readHeaders(r *bufio.Reader, headers []textHeader, fields map[string]any) error {
+	for count := 0; ; count++ {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return nil
+		}
+		if count >= MaxTextHeaders {
+			return fmt.Errorf("too many P2P-CI headers")
+		}
+		if err := readHeader(line, headers, fields); err != nil {
+			return err
+		}
+	}
+}
+
+// readHeader parses a single header line, storing it in fields under its JSON key
+func readHeader(line string, headers []textHeader, fields map[string]any) error {
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("malformed P2P-CI header %q", line)
+	}
+	name := strings.TrimSpace(kv[0])
+	value := strings.TrimSpace(kv[1])
+
+	key := name
+	for _, h := range headers {
+		if strings.EqualFold(h.name, name) {
+			key = h.key
+			break
+		}
+	}
+	fields[key] = value
+	return nil
+}
+
+// readLine reads a single CRLF (or LF) terminated line without the terminator
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > MaxTextLineLength {
+			return "", ErrTextLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// fieldString renders a decoded JSON value as a header value
+func fieldString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/data"
+)
+
+// roundTrip writes a frame with the text codec and reads it back
+func roundTrip(t *testing.T, frameType byte, header []byte) common_helpers.Frame {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := Text.WriteFrameHead(w, frameType, header, 0); err != nil {
+		t.Fatalf("WriteFrameHead: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	frame, bodyLength, err := Text.ReadFrameHead(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadFrameHead: %v\n%s", err, buf.String())
+	}
+	if frame.Type != frameType || bodyLength != 0 {
+		t.Fatalf("read frame type %d with body length %d, want type %d without body", frame.Type, bodyLength, frameType)
+	}
+	return frame
+}
+
+func TestTextServerResponseRoundTrip(t *testing.T) {
+	manifest := `{"RFC_Number":"7","RFC_Title":"Multi word title","Size":42,"Digest":"abc","Signature":"c2ln+/=="}`
+	want := data.ServerResponse{
+		Header: data.ServerResponseHeader{
+			ResponseCode:             200,
+			ResponsePhrase:           "OK",
+			ServerApplicationVersion: "P2P-CI/1.0",
+			RequestID:                "0123456789abcdef",
+			NextCursor:               "eyJuIjoiNyJ9",
+		},
+		Data: []data.ServerResponseData{
+			{
+				RFCNumber:        "7",
+				RFCTitle:         "Multi word title",
+				ClientIP:         "10.0.0.1:4000",
+				ClientUploadPort: "5000",
+				RFCDigest:        "14a1f2576313e33e50c21b0c61c3fe4aaad633c33c52670838d4660b342f6fe7",
+				RFCManifest:      manifest,
+			},
+			{
+				// Peers that do not compute digests announce RFCs without one
+				RFCNumber:        "9",
+				RFCTitle:         "Bare",
+				ClientIP:         "10.0.0.2:4001",
+				ClientUploadPort: "5001",
+			},
+			{
+				RFCNumber:        "10",
+				RFCTitle:         "Digest only",
+				ClientIP:         "10.0.0.3:4002",
+				ClientUploadPort: "5002",
+				RFCDigest:        "0263829989b6fd954f72baaf2fc64bc2e2f01d692d4de72986ea808f6e99813f",
+			},
+		},
+	}
+
+	header, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := roundTrip(t, common_helpers.ServerResponseIndex, header)
+
+	var got data.ServerResponse
+	if err := json.Unmarshal(frame.Header, &got); err != nil {
+		t.Fatalf("decoding read response: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the response\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestTextEmptyServerResponseRoundTrip(t *testing.T) {
+	want := data.ServerResponse{
+		Header: data.ServerResponseHeader{ResponseCode: 404, ResponsePhrase: "Not Found", ServerApplicationVersion: "P2P-CI/1.0"},
+		Data:   []data.ServerResponseData{},
+	}
+
+	header, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := roundTrip(t, common_helpers.ServerResponseIndex, header)
+
+	var got data.ServerResponse
+	if err := json.Unmarshal(frame.Header, &got); err != nil {
+		t.Fatalf("decoding read response: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the response\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestTextNotificationRoundTrip(t *testing.T) {
+	want := data.NotificationStruct{
+		Event:                    common_helpers.EventAdded,
+		RFCNumber:                "7",
+		RFCTitle:                 "Multi word title",
+		ClientIP:                 "10.0.0.1:4000",
+		ClientUploadPort:         "5000",
+		RFCDigest:                "14a1f2576313e33e50c21b0c61c3fe4aaad633c33c52670838d4660b342f6fe7",
+		RFCManifest:              `{"RFC_Number":"7","Signature":"c2ln"}`,
+		ServerApplicationVersion: "P2P-CI/1.0",
+		RequestID:                "0123456789abcdef",
+	}
+
+	header, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := roundTrip(t, common_helpers.NotificationIndex, header)
+
+	var got data.NotificationStruct
+	if err := json.Unmarshal(frame.Header, &got); err != nil {
+		t.Fatalf("decoding read notification: %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip changed the notification\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestTextRFCHeaderBeforeRFCLine(t *testing.T) {
+	message := "P2P-CI/1.0 200 OK\r\n\r\nDigest: abc\r\n\r\n"
+	if _, _, err := Text.ReadFrameHead(bufio.NewReader(bytes.NewBufferString(message))); err == nil {
+		t.Error("expected an error for an RFC header without an RFC line")
+	}
+}
@@ -7,4 +7,7 @@ type AddStruct struct {
 	ClientIP                 string `json:"Client_IP"`
 	ClientUploadPort         string `json:"Client_Upload_Port"`
 	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RFCDigest                string `json:"RFC_Digest,omitempty"`
+	RFCManifest              string `json:"RFC_Manifest,omitempty"`
+	RequestID                string `json:"Request_ID,omitempty"`
 }
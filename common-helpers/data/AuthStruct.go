@@ -0,0 +1,11 @@
+package data
+
+// AuthStruct represents the credential a peer authenticates its session with before publishing.
+// Credential is the shared-secret token, or the signature of the session challenge for ed25519 keys.
+type AuthStruct struct {
+	Name                     string `json:"Name"`
+	Method                   string `json:"Method"`
+	Credential               string `json:"Credential"`
+	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+}
@@ -0,0 +1,8 @@
+package data
+
+// HeartbeatStruct represents the keepalive a peer sends to renew its lease on the server index
+type HeartbeatStruct struct {
+	ClientIP                 string `json:"Client_IP"`
+	ClientUploadPort         string `json:"Client_Upload_Port"`
+	ClientApplicationVersion string `json:"Client_Application_Version"`
+}
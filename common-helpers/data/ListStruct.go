@@ -5,4 +5,14 @@ type ListStruct struct {
 	ClientIP                 string `json:"Client_IP"`
 	ClientUploadPort         string `json:"Client_Upload_Port"`
 	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+
+	// Limit is the most RFCs in a page, the server picks the page size when it is empty
+	Limit string `json:"Limit,omitempty"`
+
+	// Cursor resumes the listing after the page it was returned with, empty starts from the first RFC
+	Cursor string `json:"Cursor,omitempty"`
+
+	// Mode is pages (the default) to get one page per request, or stream to get every page in a row
+	Mode string `json:"Mode,omitempty"`
 }
@@ -7,4 +7,5 @@ type LookUpStruct struct {
 	ClientIP                 string `json:"Client_IP"`
 	ClientUploadPort         string `json:"Client_Upload_Port"`
 	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
 }
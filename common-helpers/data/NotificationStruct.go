@@ -0,0 +1,17 @@
+package data
+
+// NotificationStruct is an event the server pushes to subscribed peers, it is not the answer to any request.
+// Event is ADDED when a peer starts serving the RFC and REMOVED when it stops.
+type NotificationStruct struct {
+	Event                    string `json:"Event"`
+	RFCNumber                string `json:"RFC_Number"`
+	RFCTitle                 string `json:"RFC_Title"`
+	ClientIP                 string `json:"Client_IP"`
+	ClientUploadPort         string `json:"Client_Upload_Port"`
+	RFCDigest                string `json:"RFC_Digest,omitempty"`
+	RFCManifest              string `json:"RFC_Manifest,omitempty"`
+	ServerApplicationVersion string `json:"Server_Application_Version"`
+
+	// RequestID is the ID of the ADD or REMOVE that caused the event, empty when the peer went away
+	RequestID string `json:"Request_ID,omitempty"`
+}
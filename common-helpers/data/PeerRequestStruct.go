@@ -2,7 +2,14 @@ package data
 
 type PeerRequest struct {
 	RFCNumber string
-	Version string
-	PeerIP string
-	PeerOS string
+	Version   string
+	PeerIP    string
+	PeerOS    string
+
+	// Optional byte range of the RFC, both empty to request the whole file
+	RangeStart  string `json:",omitempty"`
+	RangeLength string `json:",omitempty"`
+
+	// RequestID traces the download in the logs of both peers and of the server
+	RequestID string `json:"Request_ID,omitempty"`
 }
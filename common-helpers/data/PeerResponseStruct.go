@@ -2,14 +2,16 @@ package data
 
 // PeerResponse represents the response from a peer
 type PeerResponseHeader struct {
-	PeerApplicationVersion    string
-	Status                    int
-	Phrase                    string
-	CurrentDateandTime        string
-	OS                        string
-	LastModifiedDateandTime   string
-	ContentLength             string
-	ContentType               string
-	RFCTitle                  string
+	PeerApplicationVersion  string
+	Status                  int
+	Phrase                  string
+	CurrentDateandTime      string
+	OS                      string
+	LastModifiedDateandTime string
+	ContentLength           string
+	ContentType             string
+	RFCTitle                string
+	ContentDigest           string
+	ContentRange            string `json:",omitempty"`
+	RequestID               string `json:"Request_ID,omitempty"`
 }
-
@@ -0,0 +1,13 @@
+package data
+
+// RFCManifest is the statement of a publisher that an RFC has the given content, signed with its ed25519 key.
+// It travels encoded as a single string, see common_helpers.EncodeManifest.
+type RFCManifest struct {
+	RFCNumber string `json:"RFC_Number"`
+	RFCTitle  string `json:"RFC_Title"`
+	Size      int64  `json:"Size"`
+	Digest    string `json:"Digest"`
+	Timestamp string `json:"Timestamp"`
+	Publisher string `json:"Publisher"`
+	Signature string `json:"Signature"`
+}
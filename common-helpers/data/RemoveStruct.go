@@ -0,0 +1,11 @@
+package data
+
+// RemoveStruct represents the data structure for withdrawing an RFC from the server index
+type RemoveStruct struct {
+	RFCNumber                string `json:"RFC_Number"`
+	RFCTitle                 string `json:"RFC_Title"`
+	ClientIP                 string `json:"Client_IP"`
+	ClientUploadPort         string `json:"Client_Upload_Port"`
+	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+}
@@ -0,0 +1,16 @@
+package data
+
+// SearchStruct represents a search of the index by RFC number, number range and title.
+// RFC_Number asks for a single number, RFC_Number_From and RFC_Number_To for a range with either end left open.
+// RFC_Title is matched case-insensitively, as a substring unless Title_Match asks for a prefix.
+type SearchStruct struct {
+	RFCNumber                string `json:"RFC_Number,omitempty"`
+	RFCNumberFrom            string `json:"RFC_Number_From,omitempty"`
+	RFCNumberTo              string `json:"RFC_Number_To,omitempty"`
+	RFCTitle                 string `json:"RFC_Title,omitempty"`
+	TitleMatch               string `json:"Title_Match,omitempty"`
+	ClientIP                 string `json:"Client_IP"`
+	ClientUploadPort         string `json:"Client_Upload_Port"`
+	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+}
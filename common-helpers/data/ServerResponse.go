@@ -5,6 +5,10 @@ type ServerResponseHeader struct {
 	ResponseCode             int    `json:"Response_Code"`
 	ResponsePhrase           string `json:"Response_Phrase"`
 	ServerApplicationVersion string `json:"Server_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+
+	// NextCursor is set on every page of a LIST response but the last, it resumes the listing after this page
+	NextCursor string `json:"Next_Cursor,omitempty"`
 }
 
 // ServerResponseData represents individual RFC data in the server response
@@ -13,6 +17,8 @@ type ServerResponseData struct {
 	RFCTitle         string `json:"RFC_Title"`
 	ClientIP         string `json:"Client_IP"`
 	ClientUploadPort string `json:"Client_Upload_Port"`
+	RFCDigest        string `json:"RFC_Digest,omitempty"`
+	RFCManifest      string `json:"RFC_Manifest,omitempty"`
 }
 
 // ServerResponse represents the complete server response structure
@@ -0,0 +1,15 @@
+package data
+
+// SubscribeStruct represents a subscription to the RFCs added to and removed from the index.
+// It selects RFCs like SearchStruct does, every later change to a selected RFC is notified until the peer disconnects.
+type SubscribeStruct struct {
+	RFCNumber                string `json:"RFC_Number,omitempty"`
+	RFCNumberFrom            string `json:"RFC_Number_From,omitempty"`
+	RFCNumberTo              string `json:"RFC_Number_To,omitempty"`
+	RFCTitle                 string `json:"RFC_Title,omitempty"`
+	TitleMatch               string `json:"Title_Match,omitempty"`
+	ClientIP                 string `json:"Client_IP"`
+	ClientUploadPort         string `json:"Client_Upload_Port"`
+	ClientApplicationVersion string `json:"Client_Application_Version"`
+	RequestID                string `json:"Request_ID,omitempty"`
+}
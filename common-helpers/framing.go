@@ -0,0 +1,83 @@
+package common_helpers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// FramePrefixSize is the size of the fixed frame prefix:
+	// 1 byte type, 4 bytes header length and 8 bytes body length (big endian)
+	FramePrefixSize = 1 + 4 + 8
+
+	// MaxFrameHeaderSize is the largest header a frame may carry
+	MaxFrameHeaderSize = 1 << 20
+
+	// MaxFrameBodySize is the largest body a frame may carry when read into memory.
+	// Streamed bodies (see ReadFrameHead) are not bound by it.
+	MaxFrameBodySize = 64 << 20
+)
+
+// ErrFrameTooLarge is returned when a frame exceeds the max frame size guards
+var ErrFrameTooLarge = errors.New("frame exceeds maximum frame size")
+
+// Frame is a single protocol message on the wire.
+// Header carries the JSON encoded struct and Body carries raw content such as RFC text.
+type Frame struct {
+	Type   byte
+	Header []byte
+	Body   []byte
+}
+
+// WriteFrameHead writes the prefix and header of a frame whose bodyLength bytes of body
+// are streamed by the caller right after, so the body never has to be held in memory
+func WriteFrameHead(w io.Writer, frameType byte, header []byte, bodyLength int64) error {
+	if len(header) > MaxFrameHeaderSize {
+		return ErrFrameTooLarge
+	}
+	if bodyLength < 0 {
+		return fmt.Errorf("negative frame body length %d", bodyLength)
+	}
+	_, err := w.Write(appendFrameHead(nil, frameType, header, bodyLength))
+	return err
+}
+
+func appendFrameHead(message []byte, frameType byte, header []byte, bodyLength int64) []byte {
+	prefix := make([]byte, FramePrefixSize)
+	prefix[0] = frameType
+	binary.BigEndian.PutUint32(prefix[1:5], uint32(len(header)))
+	binary.BigEndian.PutUint64(prefix[5:13], uint64(bodyLength))
+
+	message = append(message, prefix...)
+	return append(message, header...)
+}
+
+// ReadFrameHead reads the prefix and header of a frame and returns the length of the body,
+// leaving the body on r for the caller to stream
+func ReadFrameHead(r io.Reader) (Frame, int64, error) {
+	prefix := make([]byte, FramePrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return Frame{}, 0, err
+	}
+
+	headerLength := binary.BigEndian.Uint32(prefix[1:5])
+	bodyLength := binary.BigEndian.Uint64(prefix[5:13])
+	if headerLength > MaxFrameHeaderSize {
+		return Frame{}, 0, fmt.Errorf("%w: header %d bytes", ErrFrameTooLarge, headerLength)
+	}
+	if bodyLength > math.MaxInt64 {
+		return Frame{}, 0, fmt.Errorf("%w: body %d bytes", ErrFrameTooLarge, bodyLength)
+	}
+
+	frame := Frame{
+		Type:   prefix[0],
+		Header: make([]byte, headerLength),
+	}
+	if _, err := io.ReadFull(r, frame.Header); err != nil {
+		return Frame{}, 0, fmt.Errorf("error reading frame header: %w", err)
+	}
+	return frame, int64(bodyLength), nil
+}
@@ -0,0 +1,38 @@
+package common_helpers
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// HandshakeHello opens the connection of a peer to the server, followed by the connection mode it wants
+	HandshakeHello = "HELLO"
+
+	// ConnectionModeSingle runs the whole control protocol on the connection accepted on the server port
+	ConnectionModeSingle = "single"
+
+	// ConnectionModeDedicated makes the peer reconnect on a port allocated from the pool (legacy)
+	ConnectionModeDedicated = "dedicated"
+
+	// MaxHandshakeLineLength bounds the handshake lines exchanged before the protocol starts
+	MaxHandshakeLineLength = 128
+)
+
+// ReadHandshakeLine reads a single handshake line without the trailing newline.
+// It reads byte by byte so nothing after the line is consumed, the codec takes over the connection right after it.
+func ReadHandshakeLine(r io.Reader) (string, error) {
+	line := make([]byte, 0, MaxHandshakeLineLength)
+	b := make([]byte, 1)
+
+	for len(line) < MaxHandshakeLineLength {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return string(line), err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", fmt.Errorf("handshake line longer than %d bytes", MaxHandshakeLineLength)
+}
@@ -3,7 +3,7 @@ package common_helpers
 import (
 	"bufio"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -16,9 +16,26 @@ const (
 	MaxPortRange = 7000
 
 	// Protocol struct type indices for message identification
-	AddStructIndex    = 1
-	ListStructIndex   = 2
-	LookupStructIndex = 3
+	AddStructIndex      = 1
+	ListStructIndex     = 2
+	LookupStructIndex   = 3
+	ServerResponseIndex = 4
+	PeerRequestIndex    = 5
+	PeerResponseIndex   = 6
+	RemoveStructIndex   = 7
+	HeartbeatIndex      = 8
+	AuthIndex           = 9
+	SearchIndex         = 10
+	SubscribeIndex      = 11
+	NotificationIndex   = 12
+
+	// Ways the server delivers a LIST response, a page for every request or every page in a row
+	ListModePages  = "pages"
+	ListModeStream = "stream"
+
+	// Events the server notifies subscribed peers of
+	EventAdded   = "ADDED"
+	EventRemoved = "REMOVED"
 )
 
 // A global stack which stores all the free ports
@@ -74,7 +91,15 @@ func ReturnPort(port string) {
 
 	// Add back to the stack
 	freePortsStack = append(freePortsStack, port)
-	log.Printf("Returned port %s to pool (Available: %d)", port, len(freePortsStack))
+	slog.Debug("Returned port to pool", "port", port, "available", len(freePortsStack))
+}
+
+// FreePortCount returns how many ports are left in the pool
+func FreePortCount() int {
+	portStackMu.Lock()
+	defer portStackMu.Unlock()
+
+	return len(freePortsStack)
 }
 
 // ReadFileNamesFromClient reads comma-separated file names from a client connection.
@@ -87,20 +112,3 @@ func ReadFileNamesFromClient(conn net.Conn) ([]string, error) {
 	}
 	return strings.Split(message, ","), nil
 }
-
-// MessageReader wraps a buffered reader for reading protocol messages
-type MessageReader struct {
-	reader *bufio.Reader
-}
-
-// NewMessageReader creates a new MessageReader for the given connection
-func NewMessageReader(conn net.Conn) *MessageReader {
-	return &MessageReader{
-		reader: bufio.NewReader(conn),
-	}
-}
-
-// ReadMessage reads a single newline-terminated message from the connection
-func (mr *MessageReader) ReadMessage() ([]byte, error) {
-	return mr.reader.ReadBytes('\n')
-}
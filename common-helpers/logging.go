@@ -0,0 +1,53 @@
+package common_helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats selected by LOG_FORMAT
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// RequestIDLength is the number of random bytes in a request ID
+const RequestIDLength = 8
+
+// NewLogger builds the structured logger of a process from LOG_LEVEL (debug, info, warn or error)
+// and LOG_FORMAT (text or json), tagging every record with the component.
+// It also becomes the default logger, so log.Printf calls are written through it at info level.
+func NewLogger(component string) (*slog.Logger, error) {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", value, err)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", LogFormatText:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q, must be %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	logger := slog.New(handler).With("component", component)
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// NewRequestID returns a random ID that traces a request through the logs of the server and of both peers
+func NewRequestID() string {
+	b := make([]byte, RequestIDLength)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
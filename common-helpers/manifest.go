@@ -0,0 +1,88 @@
+package common_helpers
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"P2P/common-helpers/data"
+)
+
+// manifestSigningPrefix is prepended to the fields of a manifest to form what the publisher signs
+const manifestSigningPrefix = "P2P-CI-MANIFEST"
+
+// ErrManifestSignature is returned when a manifest is not signed by the key of its publisher
+var ErrManifestSignature = errors.New("manifest signature does not verify")
+
+// NewManifest describes an RFC file and signs the description with the key of the publisher
+func NewManifest(rfcNumber, rfcTitle, path, publisher string, key ed25519.PrivateKey) (data.RFCManifest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return data.RFCManifest{}, fmt.Errorf("error reading RFC file: %w", err)
+	}
+	digest, err := FileDigest(path)
+	if err != nil {
+		return data.RFCManifest{}, err
+	}
+
+	manifest := data.RFCManifest{
+		RFCNumber: rfcNumber,
+		RFCTitle:  rfcTitle,
+		Size:      info.Size(),
+		Digest:    digest,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Publisher: publisher,
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestSigningBytes(manifest)))
+	return manifest, nil
+}
+
+// VerifyManifest checks that a manifest was signed with the key of its publisher
+func VerifyManifest(manifest data.RFCManifest, key ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil || !ed25519.Verify(key, manifestSigningBytes(manifest), signature) {
+		return fmt.Errorf("%w: RFC %s by %s", ErrManifestSignature, manifest.RFCNumber, manifest.Publisher)
+	}
+	return nil
+}
+
+// manifestSigningBytes returns the fields of a manifest one per line, every field is covered by the signature
+func manifestSigningBytes(manifest data.RFCManifest) []byte {
+	return []byte(strings.Join([]string{
+		manifestSigningPrefix,
+		manifest.RFCNumber,
+		manifest.RFCTitle,
+		strconv.FormatInt(manifest.Size, 10),
+		manifest.Digest,
+		manifest.Timestamp,
+		manifest.Publisher,
+	}, "\n"))
+}
+
+// EncodeManifest returns the manifest as the single string carried by ADD requests and server responses
+func EncodeManifest(manifest data.RFCManifest) (string, error) {
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("error encoding manifest: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// DecodeManifest parses a manifest encoded by EncodeManifest
+func DecodeManifest(encoded string) (data.RFCManifest, error) {
+	var manifest data.RFCManifest
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return manifest, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return manifest, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,294 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are the default histogram buckets for durations in seconds,
+// from quick index requests to large transfers
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60, 300}
+
+// Metric kinds as written in the TYPE line
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// labelSeparator joins label values into the key of a sample, it cannot appear in valid UTF-8 text
+const labelSeparator = "\xff"
+
+// Registry holds the metrics of a process
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric with every combination of label values seen so far
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	// buckets are the upper bounds of a histogram, fn computes a gauge when it is scraped
+	buckets []float64
+	fn      func() float64
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+// sample is the value of a metric for one combination of label values
+type sample struct {
+	labelValues []string
+	value       float64
+
+	// bucketCounts and count are only used by histograms, value holds their sum
+	bucketCounts []uint64
+	count        uint64
+}
+
+// register adds a family to the registry, metric names must be unique
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[f.name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", f.name))
+	}
+	f.samples = make(map[string]*sample)
+	r.families[f.name] = f
+	return f
+}
+
+// sampleFor returns the sample of the label values, creating it on first use. The caller must hold f.mu.
+func (f *family) sampleFor(labelValues []string) *sample {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labelValues: slices.Clone(labelValues)}
+		if f.kind == kindHistogram {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.samples[key] = s
+	}
+	return s
+}
+
+// Counter is a count that only goes up, such as requests served
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter split by the given labels
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{f: r.register(&family{name: name, help: help, kind: kindCounter, labelNames: labelNames})}
+}
+
+// Inc adds one to the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a non-negative amount to the counter of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.f.name))
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.sampleFor(labelValues).value += v
+}
+
+// Gauge is a value that goes up and down, such as connected peers
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge split by the given labels
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{f: r.register(&family{name: name, help: help, kind: kindGauge, labelNames: labelNames})}
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn whenever the metrics are scraped
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: kindGauge, fn: fn})
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.sampleFor(labelValues).value = v
+}
+
+// Add adds to the gauge of the label values, negative amounts decrease it
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.sampleFor(labelValues).value += v
+}
+
+// Inc adds one to the gauge of the label values
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the gauge of the label values
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations into buckets, such as request durations
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, split by the given labels
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	sorted := slices.Clone(buckets)
+	slices.Sort(sorted)
+	return &Histogram{f: r.register(&family{name: name, help: help, kind: kindHistogram, labelNames: labelNames, buckets: sorted})}
+}
+
+// Observe records a value in the histogram of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.sampleFor(labelValues)
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// WriteText writes every metric in the Prometheus text exposition format, ordered by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics of the registry, typically on /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// write writes the HELP and TYPE lines of a family followed by its samples ordered by label values
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		writeSample(w, f.name, nil, nil, f.fn())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.samples))
+	for key := range f.samples {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	// Metrics without labels are reported from the start, as zero until they change
+	if len(keys) == 0 && len(f.labelNames) == 0 {
+		f.sampleFor(nil)
+		keys = append(keys, "")
+	}
+
+	for _, key := range keys {
+		s := f.samples[key]
+		if f.kind != kindHistogram {
+			writeSample(w, f.name, f.labelNames, s.labelValues, s.value)
+			continue
+		}
+
+		bucketNames := append(slices.Clone(f.labelNames), "le")
+		for i, bound := range f.buckets {
+			writeSample(w, f.name+"_bucket", bucketNames, append(slices.Clone(s.labelValues), formatValue(bound)), float64(s.bucketCounts[i]))
+		}
+		writeSample(w, f.name+"_bucket", bucketNames, append(slices.Clone(s.labelValues), "+Inf"), float64(s.count))
+		writeSample(w, f.name+"_sum", f.labelNames, s.labelValues, s.value)
+		writeSample(w, f.name+"_count", f.labelNames, s.labelValues, float64(s.count))
+	}
+}
+
+// writeSample writes a single sample line
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, v float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(v))
+	w.WriteByte('\n')
+}
+
+// formatValue formats a sample value the way Prometheus parses it
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes the text of a HELP line
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// escapeLabelValue escapes a label value for use between double quotes
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
@@ -0,0 +1,144 @@
+package common_helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// TLSSettings describes the optional TLS setup of the server or a peer, read from the environment
+type TLSSettings struct {
+	// CertFile and KeyFile hold the PEM certificate and key presented to the other side
+	CertFile string
+	KeyFile  string
+
+	// CAFile holds the PEM certificate of the team CA, the only CA that is trusted
+	CAFile string
+
+	// ClientAuth makes listeners require a client certificate issued by the CA (mutual TLS)
+	ClientAuth bool
+}
+
+// LoadTLSSettings reads TLS_CERT_FILE, TLS_KEY_FILE, TLS_CA_FILE and TLS_CLIENT_AUTH
+func LoadTLSSettings() (TLSSettings, error) {
+	settings := TLSSettings{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+		CAFile:   os.Getenv("TLS_CA_FILE"),
+	}
+
+	if value := os.Getenv("TLS_CLIENT_AUTH"); value != "" {
+		clientAuth, err := strconv.ParseBool(value)
+		if err != nil {
+			return settings, fmt.Errorf("invalid TLS_CLIENT_AUTH %q: %w", value, err)
+		}
+		settings.ClientAuth = clientAuth
+	}
+
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return settings, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if settings.ClientAuth && settings.CAFile == "" {
+		return settings, errors.New("TLS_CLIENT_AUTH needs TLS_CA_FILE to verify client certificates")
+	}
+	return settings, nil
+}
+
+// Enabled reports whether TLS is configured at all
+func (s TLSSettings) Enabled() bool {
+	return s.CertFile != "" || s.CAFile != ""
+}
+
+// ServerConfig returns the configuration of a TLS listener.
+// It needs a certificate, and the CA when client certificates are required.
+func (s TLSSettings) ServerConfig() (*tls.Config, error) {
+	if s.CertFile == "" {
+		return nil, errors.New("a TLS listener needs TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if s.ClientAuth {
+		pool, err := loadCAPool(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig returns the configuration for dialing TLS listeners.
+// Only certificates issued by the CA are accepted. Host names are not checked because
+// peers are reached on whatever address the index reports, the pinned CA vouches for them.
+// The certificate, if any, is presented to listeners requiring mutual TLS.
+func (s TLSSettings) ClientConfig() (*tls.Config, error) {
+	if s.CAFile == "" {
+		return nil, errors.New("a TLS client needs TLS_CA_FILE to verify the other side")
+	}
+	pool, err := loadCAPool(s.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Replaced by VerifyConnection, which checks the chain against the CA but not the host name
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyPinnedChain(state.PeerCertificates, pool)
+		},
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// loadCAPool reads the CA certificate into a pool of its own, the system roots are never trusted
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in TLS CA %s", caFile)
+	}
+	return pool, nil
+}
+
+// verifyPinnedChain checks that the certificate presented by a listener was issued by the pinned CA
+func verifyPinnedChain(certs []*x509.Certificate, pool *x509.CertPool) error {
+	if len(certs) == 0 {
+		return errors.New("no TLS certificate presented")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("TLS certificate not issued by the pinned CA: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// gencerts creates the self-signed team CA and the certificates of the server and peers for TLS.
+//
+//	go run ./gencerts -out certs -name server -hosts 192.168.1.10,localhost
+//	go run ./gencerts -out certs -name peer1
+//
+// The CA is created in the output directory on first use and reused afterwards.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	common_helpers "P2P/common-helpers"
+)
+
+func main() {
+	outDir := flag.String("out", "certs", "directory holding the CA and the generated certificates")
+	name := flag.String("name", "", "name of the certificate to issue, e.g. server or peer1")
+	hosts := flag.String("hosts", "", "comma separated IP addresses and host names the certificate is valid for")
+	flag.Parse()
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *outDir, err)
+	}
+
+	caCert := filepath.Join(*outDir, "ca.pem")
+	caKey := filepath.Join(*outDir, "ca-key.pem")
+	if _, err := os.Stat(caCert); os.IsNotExist(err) {
+		if err := common_helpers.GenerateCA("P2P-CI team CA", caCert, caKey); err != nil {
+			log.Fatalf("Failed to generate CA: %v", err)
+		}
+		log.Printf("Generated CA %s, share it with every peer and keep %s private", caCert, caKey)
+	}
+
+	if *name == "" {
+		return
+	}
+
+	certFile := filepath.Join(*outDir, *name+".pem")
+	keyFile := filepath.Join(*outDir, *name+"-key.pem")
+	hostList := []string{}
+	if *hosts != "" {
+		hostList = strings.Split(*hosts, ",")
+	}
+	if err := common_helpers.IssueCertificate(caCert, caKey, *name, hostList, certFile, keyFile); err != nil {
+		log.Fatalf("Failed to issue certificate: %v", err)
+	}
+	log.Printf("Issued certificate %s with key %s", certFile, keyFile)
+}
@@ -0,0 +1,42 @@
+// genkey creates the ed25519 key a peer authenticates with and prints the line registering it on the server.
+//
+//	go run ./genkey -out keys -name peer1
+//
+// Append the printed line to the file AUTH_KEYS_FILE points the server at.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	common_helpers "P2P/common-helpers"
+)
+
+func main() {
+	outDir := flag.String("out", "keys", "directory the key is written to")
+	name := flag.String("name", "", "name the peer authenticates as, e.g. peer1")
+	role := flag.String("role", "publish", "role registered for the key, publish or read")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatal("-name is required")
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *outDir, err)
+	}
+
+	keyFile := filepath.Join(*outDir, *name+".key")
+	if _, err := os.Stat(keyFile); err == nil {
+		log.Fatalf("%s already exists", keyFile)
+	}
+	public, err := common_helpers.GenerateAuthKey(keyFile)
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	log.Printf("Generated key %s, set AUTH_NAME=%s and AUTH_KEY_FILE on the peer", keyFile, *name)
+
+	fmt.Printf("%s %s %s %s\n", *name, common_helpers.AuthMethodEd25519, common_helpers.EncodePublicKey(public), *role)
+}
@@ -1,34 +1,50 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
 	"P2P/common-helpers/data"
 )
 
+// ErrDigestMismatch is returned when downloaded bytes do not match the announced digest
+var ErrDigestMismatch = errors.New("RFC digest mismatch")
+
 // CommandType represents the type of P2P command
 type CommandType string
 
 const (
-	CommandAdd    CommandType = "ADD"
-	CommandLookup CommandType = "LOOKUP"
-	CommandList   CommandType = "LIST"
-	CommandGet    CommandType = "GET"
+	CommandAdd       CommandType = "ADD"
+	CommandLookup    CommandType = "LOOKUP"
+	CommandList      CommandType = "LIST"
+	CommandGet       CommandType = "GET"
+	CommandFetch     CommandType = "FETCH"
+	CommandRemove    CommandType = "REMOVE"
+	CommandSearch    CommandType = "SEARCH"
+	CommandSubscribe CommandType = "SUBSCRIBE"
 )
 
 // Command represents a parsed user command
 type Command struct {
-	Type    CommandType
-	RFC     string
-	Version string
+	Type        CommandType
+	RFC         string
+	Version     string
 	DataSection map[string]string
+
+	// RequestID is sent with every request the command makes, so the server and the uploading peers log it too
+	RequestID string
+	Log       *slog.Logger
 }
 
 // parseCommand parses a raw command string into a Command struct
@@ -46,14 +62,24 @@ func parseCommand(input string) (*Command, error) {
 	method := strings.ToUpper(parts[0])
 	if method == "GET" {
 		return &Command{
-			Type: CommandGet,
-			RFC: "",
-			Version: "",
-			DataSection:nil,
+			Type:        CommandGet,
+			RFC:         "",
+			Version:     "",
+			DataSection: nil,
 		}, nil
 	}
-	if method != "ADD" && method != "LOOKUP" && method != "LIST" {
-		return nil, fmt.Errorf("invalid method: must be ADD, LOOKUP, or LIST")
+	if method == "FETCH" {
+		return parseFetchCommand(parts)
+	}
+	if method == "SEARCH" || method == "SUBSCRIBE" {
+		return parseSearchCommand(CommandType(method), parts)
+	}
+	// DEL is shorthand for REMOVE
+	if method == "DEL" {
+		method = "REMOVE"
+	}
+	if method != "ADD" && method != "LOOKUP" && method != "LIST" && method != "REMOVE" {
+		return nil, fmt.Errorf("invalid method: must be ADD, LOOKUP, LIST, REMOVE, GET, FETCH, SEARCH or SUBSCRIBE")
 	}
 
 	rfcString := parts[1]
@@ -62,12 +88,12 @@ func parseCommand(input string) (*Command, error) {
 	if method == "LIST" && rfcString != "ALL" {
 		return nil, fmt.Errorf("LIST requires ALL parameter")
 	}
-	if method != "LIST" && rfcString != "RFC"  {
-		return nil, fmt.Errorf("ADD and LOOKUP require RFC parameter")
+	if method != "LIST" && rfcString != "RFC" {
+		return nil, fmt.Errorf("ADD, LOOKUP and REMOVE require RFC parameter")
 	}
 
 	if method != "LIST" && !isNumeric(rfcNumber) {
-		return nil, fmt.Errorf("ADD and LOOKUP require numeric RFC number")
+		return nil, fmt.Errorf("ADD, LOOKUP and REMOVE require numeric RFC number")
 	}
 
 	var version string
@@ -95,19 +121,87 @@ func parseCommand(input string) (*Command, error) {
 	if _, ok := dataSection["Port"]; !ok {
 		return nil, fmt.Errorf("missing Port header")
 	}
-	if _, ok := dataSection["Title"]; !ok && method != "LIST" {
+	// REMOVE without a Title withdraws the RFC under every title
+	if _, ok := dataSection["Title"]; !ok && method != "LIST" && method != "REMOVE" {
 		return nil, fmt.Errorf("missing Title header")
 	}
 
 	return &Command{
-		Type:    CommandType(method),
-		RFC:     rfcNumber,
-		Version: version,
+		Type:        CommandType(method),
+		RFC:         rfcNumber,
+		Version:     version,
 		DataSection: dataSection,
 	}, nil
 }
 
-func sendGetCommand(input string) (data.PeerResponseHeader, string, string, error) {
+// parseFetchCommand parses "FETCH RFC <number> [version]"
+func parseFetchCommand(parts []string) (*Command, error) {
+	if parts[1] != "RFC" {
+		return nil, fmt.Errorf("FETCH requires RFC parameter")
+	}
+	if !isNumeric(parts[2]) {
+		return nil, fmt.Errorf("FETCH requires numeric RFC number")
+	}
+
+	version := ApplicationVersion
+	if len(parts) > 3 {
+		version = parts[3]
+	}
+
+	return &Command{
+		Type:        CommandFetch,
+		RFC:         parts[2],
+		Version:     version,
+		DataSection: map[string]string{},
+	}, nil
+}
+
+// parseSearchCommand parses "SEARCH RFC <number|first-last|*> [version] [Title:<text>] [Match:prefix|substring]".
+// Either end of a range may be left out, * searches by title only.
+func parseSearchCommand(method CommandType, parts []string) (*Command, error) {
+	if parts[1] != "RFC" {
+		return nil, fmt.Errorf("%s requires RFC parameter", method)
+	}
+
+	dataSection := make(map[string]string)
+	numbers := parts[2]
+	if first, last, isRange := strings.Cut(numbers, "-"); isRange {
+		if (first != "" && !isNumeric(first)) || (last != "" && !isNumeric(last)) {
+			return nil, fmt.Errorf("%s requires a numeric RFC number range", method)
+		}
+		dataSection["From"] = first
+		dataSection["To"] = last
+	} else if numbers != "*" {
+		if !isNumeric(numbers) {
+			return nil, fmt.Errorf("%s requires a numeric RFC number, a range or *", method)
+		}
+		dataSection["Number"] = numbers
+	}
+
+	version := ApplicationVersion
+	for _, part := range parts[3:] {
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			version = part
+			continue
+		}
+		dataSection[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if numbers == "*" && dataSection["Title"] == "" {
+		return nil, fmt.Errorf("%s RFC * requires a Title header", method)
+	}
+
+	return &Command{
+		Type:        method,
+		Version:     version,
+		DataSection: dataSection,
+	}, nil
+}
+
+// sendGetCommand sends a GET request to the peer named in the Host header.
+// On success the RFC is streamed into a temporary file in the RFCs directory whose path is returned.
+func sendGetCommand(cmd *Command, input string) (data.PeerResponseHeader, string, string, error) {
 	input = strings.TrimSpace(input)
 	if input == "" {
 		return data.PeerResponseHeader{}, "", "", fmt.Errorf("empty command")
@@ -154,24 +248,59 @@ func sendGetCommand(input string) (data.PeerResponseHeader, string, string, erro
 		return data.PeerResponseHeader{}, "", "", fmt.Errorf("missing OS header")
 	}
 
+	// Offset and Length ask for a byte range instead of the whole RFC, Length defaults to the rest of the file
+	rangeStart, rangeLength := dataSection["Offset"], dataSection["Length"]
+	userRange := rangeStart != "" || rangeLength != ""
+	if userRange {
+		if rangeStart == "" {
+			rangeStart = "0"
+		}
+		if rangeLength == "" {
+			rangeLength = fmt.Sprintf("%d", math.MaxInt64)
+		}
+		if !isNumeric(rangeStart) || !isNumeric(rangeLength) {
+			return data.PeerResponseHeader{}, "", "", fmt.Errorf("Offset and Length must be numeric")
+		}
+	}
+
+	// A previous GET of this RFC that stopped midway is resumed rather than restarted.
+	// Without a digest to check the content against, only the same peer is trusted to continue it.
+	var resume *partialState
+	if !userRange {
+		state := loadPartialState(rfcNumber)
+		if state != nil && state.resumeOffset() > 0 && (state.Digest != "" || state.Source == dataSection["Host"]) {
+			resume = state
+			rangeStart = fmt.Sprintf("%d", state.resumeOffset())
+			rangeLength = fmt.Sprintf("%d", math.MaxInt64)
+			fmt.Printf("Resuming RFC %s at byte %d of %d\n", rfcNumber, state.resumeOffset(), state.Size)
+		}
+	}
+
 	//Now we create a new TCP socket to make the GET request to the other peer
-	hostIP := strings.Split(dataSection["Host"], ":")[0]
-	hostPort := strings.Split(dataSection["Host"], ":")[1]
+	hostIP, hostPort, err := net.SplitHostPort(dataSection["Host"])
+	if err != nil {
+		return data.PeerResponseHeader{}, "", "", fmt.Errorf("invalid Host header: %w", err)
+	}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", hostIP, hostPort))
+	rawConn, err := dial(net.JoinHostPort(hostIP, hostPort), 0)
 	if err != nil {
 		return data.PeerResponseHeader{}, "", "", fmt.Errorf("error connecting to peer: %w", err)
 	}
+	defer rawConn.Close()
+	conn := codec.NewConn(rawConn, wireCodec)
 
 	//Now we first figure out on which port we just created the TCP socket
 	localAddr := conn.LocalAddr()
 
 	//Now we send the GET request to the other peer
 	request := data.PeerRequest{
-		RFCNumber: rfcNumber,
-		Version: version,
-		PeerIP: localAddr.String(),
-		PeerOS: dataSection["OS"],
+		RFCNumber:   rfcNumber,
+		Version:     version,
+		PeerIP:      localAddr.String(),
+		PeerOS:      dataSection["OS"],
+		RangeStart:  rangeStart,
+		RangeLength: rangeLength,
+		RequestID:   cmd.RequestID,
 	}
 
 	//Now we serialize the request
@@ -181,24 +310,170 @@ func sendGetCommand(input string) (data.PeerResponseHeader, string, string, erro
 	}
 
 	//Now we send the request to the other peer
-	message := append(serializedRequest, '\n')
-	if _, err := conn.Write(message); err != nil {
+	if err := conn.WriteFrame(common_helpers.PeerRequestIndex, serializedRequest, nil); err != nil {
 		return data.PeerResponseHeader{}, "", "", fmt.Errorf("error sending GET request: %w", err)
 	}
 
-	fmt.Println("GET request sent successfully")
+	cmd.Log.Debug("GET request sent", "peer", dataSection["Host"], "rfc", rfcNumber, "range_start", rangeStart, "range_length", rangeLength)
 
 	//Now we wait for the peer response which is the the peer response struct
-	reader := bufio.NewReader(conn)
-	peerResponseHeader, peerResponseData, err := readPeerResponse(reader, conn)
+	peerResponseHeader, bodyLength, err := readPeerResponse(conn, cmd.Log)
 	if err != nil {
 		return data.PeerResponseHeader{}, "", "", fmt.Errorf("error reading peer response: %w", err)
 	}
 
-	return peerResponseHeader, peerResponseData, rfcNumber, nil
+	if resume != nil {
+		// The peer must still serve the same file from where we stopped, otherwise start over
+		first, _, size, rangeErr := parseContentRange(peerResponseHeader.ContentRange)
+		stale := peerResponseHeader.Status == StatusPartialContent &&
+			(rangeErr != nil || first != resume.resumeOffset() || size != resume.Size || peerResponseHeader.ContentDigest != resume.Digest)
+		if stale || peerResponseHeader.Status == StatusRangeNotSatisfiable {
+			io.Copy(io.Discard, conn.BodyReader(bodyLength))
+			fmt.Printf("Peer no longer serves the RFC %s we started downloading, restarting\n", rfcNumber)
+			discardPartial(rfcNumber)
+			return sendGetCommand(cmd, input)
+		}
+
+		// A peer ignoring the range sends the whole file again
+		if peerResponseHeader.Status == StatusOK {
+			resume = nil
+		}
+	}
+
+	// A partial response is only checked against its Content-Range, a digest covers the whole file
+	if peerResponseHeader.Status == StatusPartialContent && userRange {
+		first, last, _, err := parseContentRange(peerResponseHeader.ContentRange)
+		if err != nil || last-first+1 != bodyLength {
+			io.Copy(io.Discard, conn.BodyReader(bodyLength))
+			return data.PeerResponseHeader{}, "", "", fmt.Errorf("peer sent range %q for a %d byte body", peerResponseHeader.ContentRange, bodyLength)
+		}
+		tempPath, err := receiveRFCBody(conn, bodyLength)
+		if err != nil {
+			return data.PeerResponseHeader{}, "", "", err
+		}
+		return peerResponseHeader, tempPath, rfcNumber, nil
+	}
+
+	// Only a successful response carries the RFC, anything else is drained.
+	// An error answer to a resumed GET leaves the .part file for a later GET to resume from.
+	if peerResponseHeader.Status != StatusOK && (peerResponseHeader.Status != StatusPartialContent || resume == nil) {
+		io.Copy(io.Discard, conn.BodyReader(bodyLength))
+		return peerResponseHeader, "", rfcNumber, nil
+	}
+
+	// The received bytes must match the digest the uploading peer announced,
+	// and the digest the user asked for if the command carries one
+	expectedDigest := peerResponseHeader.ContentDigest
+	if wanted, ok := dataSection["Digest"]; ok && wanted != expectedDigest {
+		io.Copy(io.Discard, conn.BodyReader(bodyLength))
+		return data.PeerResponseHeader{}, "", "", fmt.Errorf("%w: peer announced %q, wanted %q", ErrDigestMismatch, expectedDigest, wanted)
+	}
+
+	partPath, err := receiveRFCPart(conn, cmd, bodyLength, rfcNumber, dataSection["Host"], expectedDigest, resume)
+	if err != nil {
+		return data.PeerResponseHeader{}, "", "", err
+	}
+
+	// The caller only cares that the whole RFC arrived
+	peerResponseHeader.Status = StatusOK
+	peerResponseHeader.Phrase = "OK"
+	peerResponseHeader.ContentRange = ""
+	return peerResponseHeader, partPath, rfcNumber, nil
+}
+
+// sendGetCommandWithRetry repeats a GET whose download failed digest verification
+func sendGetCommandWithRetry(cmd *Command, input string) (data.PeerResponseHeader, string, string, error) {
+	var err error
+	for attempt := 1; attempt <= GetRetryAttempts; attempt++ {
+		var peerResponseHeader data.PeerResponseHeader
+		var tempPath, rfcNumber string
+
+		peerResponseHeader, tempPath, rfcNumber, err = sendGetCommand(cmd, input)
+		if !errors.Is(err, ErrDigestMismatch) {
+			return peerResponseHeader, tempPath, rfcNumber, err
+		}
+		cmd.Log.Warn("Download rejected", "attempt", attempt, "error", err)
+		fmt.Printf("Download attempt %d of %d rejected: %v\n", attempt, GetRetryAttempts, err)
+	}
+	return data.PeerResponseHeader{}, "", "", err
+}
+
+// receiveRFCBody streams bodyLength bytes of RFC text from the connection into a temporary file
+func receiveRFCBody(conn *codec.Conn, bodyLength int64) (string, error) {
+	if err := os.MkdirAll(RFCsDirectory, 0755); err != nil {
+		return "", fmt.Errorf("error creating RFCs directory: %w", err)
+	}
+
+	// Hidden so it is never advertised or served while incomplete
+	tempFile, err := os.CreateTemp(RFCsDirectory, ".download-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating download file: %w", err)
+	}
+	defer tempFile.Close()
+	tempFile.Chmod(0644)
+
+	received, err := copyChunks(tempFile, conn.BodyReader(bodyLength), bodyLength, conn.SetReadDeadline)
+	downloadBytes.Add(float64(received))
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("error receiving RFC: %w", err)
+	}
+
+	fmt.Printf("Received %d bytes\n", received)
+	return tempFile.Name(), nil
+}
+
+// receiveRFCPart streams the RFC text into the .part file of the RFC, after the bytes a resumed download already holds.
+// If the transfer stops midway the received bytes are kept for the next GET to resume from.
+func receiveRFCPart(conn *codec.Conn, cmd *Command, bodyLength int64, rfcNumber, source, expectedDigest string, resume *partialState) (string, error) {
+	if err := os.MkdirAll(RFCsDirectory, 0755); err != nil {
+		return "", fmt.Errorf("error creating RFCs directory: %w", err)
+	}
+
+	state := resume
+	flags := os.O_WRONLY | os.O_CREATE
+	if state == nil {
+		// A fresh download replaces whatever was left of an earlier one
+		state = &partialState{RFCNumber: rfcNumber, Digest: expectedDigest, Size: bodyLength}
+		flags |= os.O_TRUNC
+	}
+	state.Source = source
+
+	partFile, err := os.OpenFile(partFilePath(rfcNumber), flags, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error opening download file: %w", err)
+	}
+	defer partFile.Close()
+	if err := state.save(); err != nil {
+		return "", err
+	}
+
+	writer := &partWriter{file: partFile, state: state, offset: state.resumeOffset()}
+	received, err := copyChunks(writer, conn.BodyReader(bodyLength), bodyLength, conn.SetReadDeadline)
+	downloadBytes.Add(float64(received))
+	if err != nil {
+		if saveErr := state.save(); saveErr != nil {
+			cmd.Log.Error("Error saving partial state", "rfc", rfcNumber, "error", saveErr)
+		}
+		return "", fmt.Errorf("error receiving RFC, %d of %d bytes kept for the next GET to resume: %w", state.resumeOffset(), state.Size, err)
+	}
+	fmt.Printf("Received %d bytes\n", received)
+
+	// The whole file is verified, including the bytes received before a resume
+	digest, err := common_helpers.FileDigest(partFilePath(rfcNumber))
+	if err != nil {
+		return "", fmt.Errorf("error computing digest: %w", err)
+	}
+	if state.Digest != "" && digest != state.Digest {
+		discardPartial(rfcNumber)
+		return "", fmt.Errorf("%w: received %s, expected %s", ErrDigestMismatch, digest, state.Digest)
+	}
+
+	os.Remove(partStatePath(rfcNumber))
+	return partFilePath(rfcNumber), nil
 }
 
-//Format the server response converting the struct to a string
+// Format the server response converting the struct to a string
 func formatServerResponse(serverResponse data.ServerResponse) string {
 	var result strings.Builder
 
@@ -208,20 +483,32 @@ func formatServerResponse(serverResponse data.ServerResponse) string {
 		serverResponse.Header.ResponseCode,
 		serverResponse.Header.ResponsePhrase))
 
+	result.WriteString(formatServerResponseData(serverResponse.Data))
+	return result.String()
+}
+
+// formatServerResponseData formats the RFCs of a server response, one per line
+func formatServerResponseData(responseData []data.ServerResponseData) string {
+	var result strings.Builder
+
 	// For each RFC in the data array
-	for _, rfcData := range serverResponse.Data {
-		result.WriteString(fmt.Sprintf("%s %s %s %s\r\n",
+	for _, rfcData := range responseData {
+		result.WriteString(fmt.Sprintf("%s %s %s %s",
 			rfcData.RFCNumber,
 			rfcData.RFCTitle,
 			rfcData.ClientIP,
 			rfcData.ClientUploadPort))
+		if rfcData.RFCDigest != "" {
+			result.WriteString(" " + rfcData.RFCDigest)
+		}
+		result.WriteString("\r\n")
 	}
 
 	return result.String()
 }
 
-//Format the peer response header converting the struct to a string
-func formatPeerResponse(peerResponseHeader data.PeerResponseHeader, peerResponseData string) string {
+// Format the peer response header converting the struct to a string
+func formatPeerResponse(peerResponseHeader data.PeerResponseHeader) string {
 	var result strings.Builder
 
 	// First line: version <sp> status code <sp> phrase
@@ -250,48 +537,64 @@ func formatPeerResponse(peerResponseHeader data.PeerResponseHeader, peerResponse
 		result.WriteString(fmt.Sprintf("RFC-Title: %s\r\n", peerResponseHeader.RFCTitle))
 	}
 
-	// Empty line before data
-	result.WriteString("\r\n")
+	// Content-Digest header (if available)
+	if peerResponseHeader.ContentDigest != "" {
+		result.WriteString(fmt.Sprintf("Content-Digest: %s\r\n", peerResponseHeader.ContentDigest))
+	}
 
-	// Data
-	result.WriteString(peerResponseData)
+	// Content-Range header (for partial responses)
+	if peerResponseHeader.ContentRange != "" {
+		result.WriteString(fmt.Sprintf("Content-Range: %s\r\n", peerResponseHeader.ContentRange))
+	}
+
+	// Empty line before data, the data itself is saved to disk rather than printed
+	result.WriteString("\r\n")
 
 	return result.String()
 }
 
-func readPeerResponse(reader *bufio.Reader, conn net.Conn) (data.PeerResponseHeader, string, error) {
-	fmt.Println("Reading peer response")
+// readPeerResponse reads the head of a peer response and returns the length of the RFC text that follows
+func readPeerResponse(conn *codec.Conn, logger *slog.Logger) (data.PeerResponseHeader, int64, error) {
 	conn.SetReadDeadline(time.Now().Add(PeerResponseTimeout))
 
-	peerResponseRaw, err := reader.ReadBytes(byte('\n'))
-	fmt.Println("Peer response read successfully")
+	frame, bodyLength, err := conn.ReadFrameHead()
 	if err != nil {
-		return data.PeerResponseHeader{}, "", fmt.Errorf("error reading peer response: %w", err)
+		return data.PeerResponseHeader{}, 0, fmt.Errorf("error reading peer response: %w", err)
 	}
 	conn.SetReadDeadline(time.Time{})
-	peerResponseHeader, peerResponseData, err := DeserializePeerResponseData(peerResponseRaw)
-	fmt.Println("Peer response deserialized successfully")
+
+	if frame.Type != common_helpers.PeerResponseIndex {
+		return data.PeerResponseHeader{}, 0, fmt.Errorf("unexpected message type %d in peer response", frame.Type)
+	}
+
+	peerResponseHeader, err := DeserializePeerResponseHeader(frame.Header)
 	if err != nil {
-		return data.PeerResponseHeader{}, "", fmt.Errorf("error deserializing peer response: %w", err)
+		return data.PeerResponseHeader{}, 0, fmt.Errorf("error deserializing peer response: %w", err)
+	}
+	logger.Debug("Peer response received", "peer", conn.RemoteAddr().String(), "status", peerResponseHeader.Status,
+		"content_length", bodyLength, "content_range", peerResponseHeader.ContentRange)
+
+	// The announced Content-Length must match what is actually on the wire
+	if peerResponseHeader.ContentLength != strconv.FormatInt(bodyLength, 10) {
+		return data.PeerResponseHeader{}, 0, fmt.Errorf("Content-Length %s does not match body of %d bytes",
+			peerResponseHeader.ContentLength, bodyLength)
 	}
 
-	return peerResponseHeader, peerResponseData, nil
+	return peerResponseHeader, bodyLength, nil
 }
 
-// saveRFCFile saves the received RFC file to the RFCs directory
-func saveRFCFile(rfcNumber string, title string, content string) error {
-	// Ensure RFCs directory exists
-	rfcsDir := "./RFCs"
-	if err := os.MkdirAll(rfcsDir, 0755); err != nil {
-		return fmt.Errorf("error creating RFCs directory: %w", err)
-	}
+// rfcFilePath returns the path of an RFC file in format: <RFC_NUMBER>_<TITLE>.txt
+func rfcFilePath(rfcNumber string, title string) string {
+	return fmt.Sprintf("%s/%s_%s.txt", RFCsDirectory, rfcNumber, title)
+}
 
-	// Create filename in format: <RFC_NUMBER>_<TITLE>.txt
-	filename := fmt.Sprintf("%s_%s.txt", rfcNumber, title)
-	filepath := fmt.Sprintf("%s/%s", rfcsDir, filename)
+// saveRFCFile moves a completely received RFC file into place in the RFCs directory
+func saveRFCFile(rfcNumber string, title string, tempPath string) error {
+	filepath := rfcFilePath(rfcNumber, title)
 
-	// Write the file content
-	if err := os.WriteFile(filepath, []byte(content), 0644); err != nil {
+	// Move the downloaded file into place
+	if err := os.Rename(tempPath, filepath); err != nil {
+		os.Remove(tempPath)
 		return fmt.Errorf("error writing RFC file: %w", err)
 	}
 
@@ -299,32 +602,34 @@ func saveRFCFile(rfcNumber string, title string, content string) error {
 	return nil
 }
 
-// readServerResponse reads a server response from the connection
-func readServerResponse(reader *bufio.Reader, conn net.Conn) (data.ServerResponse, error) {
-	conn.SetReadDeadline(time.Now().Add(ServerResponseTimeout))
-	
-	serverResponse, err := reader.ReadBytes(byte('\n'))
-	
+// readServerResponse waits for the server response to a request
+func readServerResponse(request *pendingRequest) (data.ServerResponse, error) {
+	serverResponseData, err := request.await(ServerResponseTimeout)
 	if err != nil {
-			return data.ServerResponse{}, fmt.Errorf("error reading server response: %w", err)
-	}
-	conn.SetReadDeadline(time.Time{})
-	serverResponseData, err := DeserializeServerResponse(serverResponse)
-	if err != nil {
-			return data.ServerResponse{}, fmt.Errorf("error deserializing server response: %w", err)
+		return data.ServerResponse{}, fmt.Errorf("error reading server response: %w", err)
 	}
 
 	return serverResponseData, nil
 }
 
 // sendAddRequest sends an ADD request to the server
-func sendAddRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
+func sendAddRequest(conn *controlConn, cmd *Command) error {
+	// Use the digest given in the command, or compute it if we have the file ourselves
+	path := rfcFilePath(cmd.RFC, cmd.DataSection["Title"])
+	digest, ok := cmd.DataSection["Digest"]
+	if !ok {
+		digest, _ = common_helpers.FileDigest(path)
+	}
+
 	addStruct := data.AddStruct{
 		RFCNumber:                cmd.RFC,
 		RFCTitle:                 cmd.DataSection["Title"],
 		ClientIP:                 cmd.DataSection["Host"],
 		ClientUploadPort:         cmd.DataSection["Port"],
 		ClientApplicationVersion: cmd.Version,
+		RFCDigest:                digest,
+		RFCManifest:              signManifest(cmd.RFC, cmd.DataSection["Title"], path, digest),
+		RequestID:                cmd.RequestID,
 	}
 
 	serialized, err := SerializeAddStruct(addStruct)
@@ -332,20 +637,19 @@ func sendAddRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
 		return fmt.Errorf("error serializing AddStruct: %w", err)
 	}
 
-	message := append([]byte{byte(common_helpers.AddStructIndex)}, serialized...)
-	message = append(message, '\n')
-
-	if _, err := conn.Write(message); err != nil {
+	request, err := conn.send(common_helpers.AddStructIndex, cmd.RequestID, serialized)
+	if err != nil {
 		return fmt.Errorf("error sending ADD request: %w", err)
 	}
+	defer request.close()
 
-	fmt.Println("ADD request sent successfully")
+	cmd.Log.Debug("ADD request sent", "rfc", cmd.RFC, "digest", digest)
 
 	//Now we wait for the server response
-	serverResponse, err := readServerResponse(reader, conn)
+	serverResponse, err := readServerResponse(request)
 	serverResponseString := formatServerResponse(serverResponse)
 	fmt.Printf("Server response:\n%s", serverResponseString)
-	if err != nil { 
+	if err != nil {
 		return fmt.Errorf("error reading server response: %w", err)
 	}
 
@@ -354,6 +658,60 @@ func sendAddRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
 		fmt.Println("RFC added successfully")
 	case StatusBadRequest:
 		fmt.Println("Error: Bad Request")
+	case StatusUnauthorized:
+		fmt.Println("Error: Unauthorized, authenticate to publish")
+	case StatusForbidden:
+		fmt.Println("Error: Forbidden")
+	case StatusVersionNotSupported:
+		fmt.Println("Error: P2P-CI Version Not Supported")
+	default:
+		fmt.Println("Error: Unknown server response code")
+	}
+	return nil
+}
+
+// sendRemoveRequest sends a REMOVE request to the server, withdrawing one of our RFCs from the index
+func sendRemoveRequest(conn *controlConn, cmd *Command) error {
+	removeStruct := data.RemoveStruct{
+		RFCNumber:                cmd.RFC,
+		RFCTitle:                 cmd.DataSection["Title"],
+		ClientIP:                 cmd.DataSection["Host"],
+		ClientUploadPort:         cmd.DataSection["Port"],
+		ClientApplicationVersion: cmd.Version,
+		RequestID:                cmd.RequestID,
+	}
+
+	serialized, err := SerializeRemoveStruct(removeStruct)
+	if err != nil {
+		return fmt.Errorf("error serializing RemoveStruct: %w", err)
+	}
+
+	request, err := conn.send(common_helpers.RemoveStructIndex, cmd.RequestID, serialized)
+	if err != nil {
+		return fmt.Errorf("error sending REMOVE request: %w", err)
+	}
+	defer request.close()
+
+	cmd.Log.Debug("REMOVE request sent", "rfc", cmd.RFC)
+
+	//Now we wait for the server response
+	serverResponse, err := readServerResponse(request)
+	if err != nil {
+		return fmt.Errorf("error reading server response: %w", err)
+	}
+	fmt.Printf("Server response:\n%s", formatServerResponse(serverResponse))
+
+	switch serverResponse.Header.ResponseCode {
+	case StatusOK:
+		fmt.Println("RFC removed successfully")
+	case StatusBadRequest:
+		fmt.Println("Error: Bad Request")
+	case StatusUnauthorized:
+		fmt.Println("Error: Unauthorized, authenticate to publish")
+	case StatusForbidden:
+		fmt.Println("Error: Forbidden")
+	case StatusNotFound:
+		fmt.Println("Error: RFC is not in the index")
 	case StatusVersionNotSupported:
 		fmt.Println("Error: P2P-CI Version Not Supported")
 	default:
@@ -363,13 +721,14 @@ func sendAddRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
 }
 
 // sendLookupRequest sends a LOOKUP request to the server
-func sendLookupRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
+func sendLookupRequest(conn *controlConn, cmd *Command) error {
 	lookupStruct := data.LookUpStruct{
 		RFCNumber:                cmd.RFC,
 		RFCTitle:                 cmd.DataSection["Title"],
 		ClientIP:                 cmd.DataSection["Host"],
 		ClientUploadPort:         cmd.DataSection["Port"],
 		ClientApplicationVersion: cmd.Version,
+		RequestID:                cmd.RequestID,
 	}
 
 	serialized, err := SerializeLookUpStruct(lookupStruct)
@@ -377,17 +736,16 @@ func sendLookupRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error
 		return fmt.Errorf("error serializing LookUpStruct: %w", err)
 	}
 
-	message := append([]byte{byte(common_helpers.LookupStructIndex)}, serialized...)
-	message = append(message, '\n')
-
-	if _, err := conn.Write(message); err != nil {
+	request, err := conn.send(common_helpers.LookupStructIndex, cmd.RequestID, serialized)
+	if err != nil {
 		return fmt.Errorf("error sending LOOKUP request: %w", err)
 	}
+	defer request.close()
 
-	fmt.Println("LOOKUP request sent successfully")
+	cmd.Log.Debug("LOOKUP request sent", "rfc", cmd.RFC)
 
 	//Now we wait for the server response
-	serverResponse, err := readServerResponse(reader, conn)
+	serverResponse, err := readServerResponse(request)
 	serverResponseString := formatServerResponse(serverResponse)
 	if err != nil {
 		return fmt.Errorf("error reading server response: %w", err)
@@ -412,76 +770,235 @@ func sendLookupRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error
 }
 
 // sendListRequest sends a LIST request to the server
-func sendListRequest(conn net.Conn, cmd *Command, reader *bufio.Reader) error {
+func sendListRequest(conn *controlConn, cmd *Command) error {
 	listStruct := data.ListStruct{
 		ClientIP:                 cmd.DataSection["Host"],
 		ClientUploadPort:         cmd.DataSection["Port"],
 		ClientApplicationVersion: cmd.Version,
+		RequestID:                cmd.RequestID,
+		Limit:                    cmd.DataSection["Limit"],
+		Mode:                     cmd.DataSection["Mode"],
 	}
+	stream := listStruct.Mode == common_helpers.ListModeStream
+
+	pages := 0
+	entries := 0
+	var request *pendingRequest
+	defer func() {
+		if request != nil {
+			request.close()
+		}
+	}()
+	for {
+		// In stream mode the server sends every page after the first request
+		if pages == 0 || !stream {
+			serialized, err := SerializeListStruct(listStruct)
+			if err != nil {
+				return fmt.Errorf("error serializing ListStruct: %w", err)
+			}
 
-	serialized, err := SerializeListStruct(listStruct)
-	if err != nil {
-		return fmt.Errorf("error serializing ListStruct: %w", err)
+			// Every page is asked for under the ID of the command, the request for the previous one is done
+			if request != nil {
+				request.close()
+			}
+			request, err = conn.send(common_helpers.ListStructIndex, cmd.RequestID, serialized)
+			if err != nil {
+				return fmt.Errorf("error sending LIST request: %w", err)
+			}
+
+			cmd.Log.Debug("LIST request sent", "cursor", listStruct.Cursor)
+		}
+
+		//Now we wait for the server response
+		serverResponse, err := readServerResponse(request)
+		if err != nil {
+			return fmt.Errorf("error reading server response: %w", err)
+		}
+
+		//The status line is printed once, the RFCs of the following pages are printed as they come
+		if pages == 0 {
+			fmt.Printf("Server response:\n%s", formatServerResponse(serverResponse))
+		} else {
+			fmt.Print(formatServerResponseData(serverResponse.Data))
+		}
+		pages++
+		entries += len(serverResponse.Data)
+
+		switch serverResponse.Header.ResponseCode {
+		case StatusOK:
+		case StatusBadRequest:
+			fmt.Println("Error: Bad Request")
+			return nil
+		case StatusVersionNotSupported:
+			fmt.Println("Error: P2P-CI Version Not Supported")
+			return nil
+		default:
+			fmt.Println("Error: Unknown server response code")
+			return nil
+		}
+
+		if serverResponse.Header.NextCursor == "" {
+			break
+		}
+		listStruct.Cursor = serverResponse.Header.NextCursor
+	}
+
+	cmd.Log.Debug("LIST response received", "pages", pages, "entries", entries)
+	fmt.Println("RFC list response received successfully")
+	return nil
+}
+
+// sendSearchRequest sends a SEARCH request to the server
+func sendSearchRequest(conn *controlConn, cmd *Command) error {
+	searchStruct := data.SearchStruct{
+		RFCNumber:                cmd.DataSection["Number"],
+		RFCNumberFrom:            cmd.DataSection["From"],
+		RFCNumberTo:              cmd.DataSection["To"],
+		RFCTitle:                 cmd.DataSection["Title"],
+		TitleMatch:               cmd.DataSection["Match"],
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientApplicationVersion: cmd.Version,
+		RequestID:                cmd.RequestID,
 	}
 
-	message := append([]byte{byte(common_helpers.ListStructIndex)}, serialized...)
-	message = append(message, '\n')
+	serialized, err := SerializeSearchStruct(searchStruct)
+	if err != nil {
+		return fmt.Errorf("error serializing SearchStruct: %w", err)
+	}
 
-	if _, err := conn.Write(message); err != nil {
-		return fmt.Errorf("error sending LIST request: %w", err)
+	request, err := conn.send(common_helpers.SearchIndex, cmd.RequestID, serialized)
+	if err != nil {
+		return fmt.Errorf("error sending SEARCH request: %w", err)
 	}
+	defer request.close()
 
-	fmt.Println("LIST request sent successfully")
+	cmd.Log.Debug("SEARCH request sent", "number", searchStruct.RFCNumber, "from", searchStruct.RFCNumberFrom,
+		"to", searchStruct.RFCNumberTo, "title", searchStruct.RFCTitle, "title_match", searchStruct.TitleMatch)
 
-	//Now we wait for server response
 	//Now we wait for the server response
-	serverResponse, err := readServerResponse(reader, conn)
+	serverResponse, err := readServerResponse(request)
 	if err != nil {
 		return fmt.Errorf("error reading server response: %w", err)
 	}
-
-	serverResponseString := formatServerResponse(serverResponse)
-	fmt.Printf("Server response:\n%s", serverResponseString)
+	fmt.Printf("Server response:\n%s", formatServerResponse(serverResponse))
 
 	switch serverResponse.Header.ResponseCode {
 	case StatusOK:
-		fmt.Println("RFC list response received successfully")
+		fmt.Printf("Matching RFCs: %d\n", len(serverResponse.Data))
 	case StatusBadRequest:
 		fmt.Println("Error: Bad Request")
+	case StatusUnauthorized:
+		fmt.Println("Error: Unauthorized, authenticate to search")
+	case StatusNotFound:
+		fmt.Println("No RFC matches the search")
 	case StatusVersionNotSupported:
 		fmt.Println("Error: P2P-CI Version Not Supported")
 	default:
 		fmt.Println("Error: Unknown server response code")
 	}
 	return nil
+}
+
+// sendSubscribeRequest subscribes to the RFCs selected like a SEARCH, the server then notifies us when they are added or removed.
+// Accepted subscriptions are recorded on the link, which sends them again after a reconnect.
+func sendSubscribeRequest(link *serverLink, conn *controlConn, cmd *Command) error {
+	subscribeStruct := data.SubscribeStruct{
+		RFCNumber:                cmd.DataSection["Number"],
+		RFCNumberFrom:            cmd.DataSection["From"],
+		RFCNumberTo:              cmd.DataSection["To"],
+		RFCTitle:                 cmd.DataSection["Title"],
+		TitleMatch:               cmd.DataSection["Match"],
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientApplicationVersion: cmd.Version,
+		RequestID:                cmd.RequestID,
+	}
+
+	serialized, err := SerializeSubscribeStruct(subscribeStruct)
+	if err != nil {
+		return fmt.Errorf("error serializing SubscribeStruct: %w", err)
+	}
+
+	request, err := conn.send(common_helpers.SubscribeIndex, cmd.RequestID, serialized)
+	if err != nil {
+		return fmt.Errorf("error sending SUBSCRIBE request: %w", err)
+	}
+	defer request.close()
+
+	cmd.Log.Debug("SUBSCRIBE request sent", "number", subscribeStruct.RFCNumber, "from", subscribeStruct.RFCNumberFrom,
+		"to", subscribeStruct.RFCNumberTo, "title", subscribeStruct.RFCTitle, "title_match", subscribeStruct.TitleMatch)
+
+	//Now we wait for the server response
+	serverResponse, err := readServerResponse(request)
+	if err != nil {
+		return fmt.Errorf("error reading server response: %w", err)
+	}
+	fmt.Printf("Server response:\n%s", formatServerResponse(serverResponse))
 
+	switch serverResponse.Header.ResponseCode {
+	case StatusOK:
+		link.subscribed(subscribeStruct)
+		fmt.Println("Subscribed, matching RFCs will be announced as they are added or removed")
+	case StatusBadRequest:
+		fmt.Println("Error: Bad Request")
+	case StatusUnauthorized:
+		fmt.Println("Error: Unauthorized, authenticate to subscribe")
+	case StatusVersionNotSupported:
+		fmt.Println("Error: P2P-CI Version Not Supported")
+	default:
+		fmt.Println("Error: Unknown server response code")
+	}
+	return nil
 }
 
 // executeCommand parses and executes a command
-func executeCommand(conn net.Conn, input string, reader *bufio.Reader) error {
+func executeCommand(link *serverLink, input string) error {
 	cmd, err := parseCommand(input)
 	if err != nil {
 		return err
 	}
+	conn := link.current()
+
+	// Every request of the command carries the same ID, a GET can be followed from the server to the uploading peer
+	cmd.RequestID = common_helpers.NewRequestID()
+	cmd.Log = conn.log.With("request_id", cmd.RequestID, "command", cmd.Type)
 
 	switch cmd.Type {
 	case CommandAdd:
-		return sendAddRequest(conn, cmd, reader)
+		return sendAddRequest(conn, cmd)
 	case CommandLookup:
-		return sendLookupRequest(conn, cmd, reader)
+		return sendLookupRequest(conn, cmd)
 	case CommandList:
-		return sendListRequest(conn, cmd, reader)
+		return sendListRequest(conn, cmd)
+	case CommandRemove:
+		return sendRemoveRequest(conn, cmd)
+	case CommandSearch:
+		return sendSearchRequest(conn, cmd)
+	case CommandSubscribe:
+		return sendSubscribeRequest(link, conn, cmd)
+	case CommandFetch:
+		started := time.Now()
+		if err := fetchRFC(conn, cmd); err != nil {
+			recordDownload(cmd, started, downloadFailed)
+			return err
+		}
+		recordDownload(cmd, started, downloadOK)
+		return nil
 	case CommandGet:
 		var wg sync.WaitGroup
 		var peerResponseHeader data.PeerResponseHeader
-		var peerResponseData string
+		var tempPath string
 		var rfcNumber string
 		var getErr error
 
+		// Anything short of a saved or displayed RFC counts as failed
+		started := time.Now()
+		result := downloadFailed
+		defer func() { recordDownload(cmd, started, result) }()
+
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			peerResponseHeader, peerResponseData, rfcNumber, getErr = sendGetCommand(input)
+			peerResponseHeader, tempPath, rfcNumber, getErr = sendGetCommandWithRetry(cmd, input)
 			if getErr != nil {
 				fmt.Printf("Error sending GET request: %v\n", getErr)
 				return
@@ -495,8 +1012,11 @@ func executeCommand(conn net.Conn, input string, reader *bufio.Reader) error {
 		}
 
 		// Format and display the peer response
-		formattedResponse := formatPeerResponse(peerResponseHeader, peerResponseData)
+		formattedResponse := formatPeerResponse(peerResponseHeader)
 		fmt.Printf("%s\n", formattedResponse)
+		if peerResponseHeader.Status != StatusOK && peerResponseHeader.Status != StatusPartialContent {
+			result = downloadRefused
+		}
 
 		// Save the RFC file if the request was successful
 		if peerResponseHeader.Status == StatusOK {
@@ -506,9 +1026,29 @@ func executeCommand(conn net.Conn, input string, reader *bufio.Reader) error {
 				title = "RFC" // Fallback title if not provided
 			}
 
-			if err := saveRFCFile(rfcNumber, title, peerResponseData); err != nil {
+			// Only RFCs signed by a trusted publisher are kept once we trust any
+			if err := verifySignedRFC(conn, cmd, rfcNumber, title, tempPath); err != nil {
+				os.Remove(tempPath)
+				return err
+			}
+			if err := saveRFCFile(rfcNumber, title, tempPath); err != nil {
 				fmt.Printf("Warning: Failed to save RFC file: %v\n", err)
+			} else {
+				result = downloadOK
+			}
+		}
+
+		// A byte range is only part of the RFC, so it is displayed rather than saved
+		if peerResponseHeader.Status == StatusPartialContent {
+			defer os.Remove(tempPath)
+			rangeFile, err := os.Open(tempPath)
+			if err != nil {
+				return fmt.Errorf("error opening received range: %w", err)
 			}
+			defer rangeFile.Close()
+			io.Copy(os.Stdout, rangeFile)
+			fmt.Println()
+			result = downloadOK
 		}
 
 		return nil
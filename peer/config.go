@@ -10,15 +10,58 @@ const (
 	// DefaultServerPort is the default port for connecting to server
 	DefaultServerPort = "7734"
 
+	// RFCsDirectory is where RFC files are served from and downloaded to
+	RFCsDirectory = "./RFCs"
+
+	// DefaultProtocolEncoding is the wire encoding used unless PROTOCOL_ENCODING says otherwise
+	DefaultProtocolEncoding = "json"
+
 	// ServerResponseTimeout is the timeout for waiting for server responses
 	ServerResponseTimeout = 5 * time.Second
 
-	// PeerResponseTimeout is the timeout for waiting for peer responses
+	// ControlResponseQueueLength is how many responses to one request are read ahead of the command, a streamed LIST sends several
+	ControlResponseQueueLength = 16
+
+	// PeerResponseTimeout is the timeout for waiting for peer responses.
+	// During a transfer it applies to every chunk rather than the whole file.
 	PeerResponseTimeout = 50 * time.Second
 
+	// DefaultHeartbeatInterval is how often the server is told we are alive, well within its lease duration
+	DefaultHeartbeatInterval = 30 * time.Second
+
+	// ReconnectInitialBackoff and ReconnectMaxBackoff bound the wait between attempts to reconnect to the server,
+	// which doubles after every failed attempt
+	ReconnectInitialBackoff = 1 * time.Second
+	ReconnectMaxBackoff     = 30 * time.Second
+
+	// DefaultRFCWatchInterval is how often the RFCs directory is checked for files added, changed or deleted
+	DefaultRFCWatchInterval = 2 * time.Second
+
+	// RFCWatchSettle is how long a file must stay unchanged before the server is told about it
+	RFCWatchSettle = 1 * time.Second
+
+	// GetRetryAttempts is how many times a GET is tried when the download fails verification
+	GetRetryAttempts = 3
+
+	// PartialStateInterval is how many bytes a GET receives between saves of its resume state
+	PartialStateInterval = 1 << 20
+
+	// FetchChunkSize is the size of the byte ranges a FETCH spreads over the peers holding an RFC
+	FetchChunkSize = 1 << 20
+
+	// TransferChunkSize is the size of the chunks RFC files are streamed in
+	TransferChunkSize = 64 * 1024
+
+	// MetricsReadHeaderTimeout bounds how long a metrics scrape may take to send its headers
+	MetricsReadHeaderTimeout = 5 * time.Second
+
 	// HTTP status code equivalents for P2P protocol
 	StatusOK                  = 200
+	StatusPartialContent      = 206
 	StatusBadRequest          = 400
+	StatusUnauthorized        = 401
+	StatusForbidden           = 403
 	StatusNotFound            = 404
+	StatusRangeNotSatisfiable = 416
 	StatusVersionNotSupported = 505
 )
@@ -0,0 +1,208 @@
+// This file reads the control connection to the server, telling the responses to our requests from the events it pushes
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
+	"P2P/common-helpers/data"
+)
+
+// controlConn is our connection to the server.
+// A single goroutine reads it: responses are handed to the request they echo the ID of, notifications are printed as they come.
+// Several requests may be in flight at once, the server answers each under its own ID.
+type controlConn struct {
+	*codec.Conn
+
+	// peerID is the identity the server assigned to this session, it owns everything we publish on it
+	peerID string
+
+	// log carries the peer ID of the session
+	log *slog.Logger
+
+	// mu guards pending and unanswered
+	mu sync.Mutex
+
+	// pending are the requests waiting for responses by request ID, unanswered holds them in the order they were sent
+	pending    map[string]*pendingRequest
+	unanswered []*pendingRequest
+
+	// published are the files of the RFCs directory the server accepted on this session, by file name.
+	// registerRFCs fills it before the connection is handed out, only the watcher changes it after.
+	published map[string]localRFC
+
+	// closed is closed once the connection can no longer be read, err tells why
+	closed chan struct{}
+	err    error
+}
+
+// pendingRequest is a request sent to the server whose responses we are waiting for
+type pendingRequest struct {
+	id   string
+	conn *controlConn
+
+	// responses holds the responses read but not yet taken, a LIST in stream mode gets several for one request
+	responses chan data.ServerResponse
+
+	// done is closed with the request, responses that still come are no longer waited for
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newControlConn starts reading the connection to the server of the session assigned peerID
+func newControlConn(conn *codec.Conn, peerID string) *controlConn {
+	cc := &controlConn{
+		Conn:      conn,
+		peerID:    peerID,
+		log:       peerLog.With("peer_id", peerID),
+		pending:   make(map[string]*pendingRequest),
+		published: make(map[string]localRFC),
+		closed:    make(chan struct{}),
+	}
+	go cc.readLoop()
+	return cc
+}
+
+// readLoop reads every frame the server sends until the connection fails
+func (cc *controlConn) readLoop() {
+	defer close(cc.closed)
+
+	for {
+		frame, err := cc.ReadFrame()
+		if err != nil {
+			cc.err = err
+			return
+		}
+
+		switch frame.Type {
+		case common_helpers.NotificationIndex:
+			cc.handleNotification(frame.Header)
+		case common_helpers.ServerResponseIndex:
+			response, err := DeserializeServerResponse(frame.Header)
+			if err != nil {
+				cc.log.Warn("Error deserializing server response", "error", err)
+				continue
+			}
+			cc.route(response)
+		default:
+			cc.log.Warn("Unexpected message type from the server", "type", frame.Type)
+		}
+	}
+}
+
+// route hands a response to the request it answers.
+// Responses to requests nobody waits for anymore, such as ones that timed out, are dropped rather than given to the next command.
+func (cc *controlConn) route(response data.ServerResponse) {
+	requestID := response.Header.RequestID
+
+	cc.mu.Lock()
+	request, ok := cc.pending[requestID]
+	// Servers that do not echo request IDs answer in order, so the oldest request gets it
+	if !ok && requestID == "" && len(cc.unanswered) > 0 {
+		request, ok = cc.unanswered[0], true
+	}
+	cc.mu.Unlock()
+
+	if !ok {
+		cc.log.Warn("Dropping server response to no pending request", "request_id", requestID,
+			"code", response.Header.ResponseCode, "phrase", response.Header.ResponsePhrase)
+		return
+	}
+
+	// A command slower than the server holds up the reading, rather than losing part of a streamed LIST
+	select {
+	case request.responses <- response:
+	case <-request.done:
+		cc.log.Warn("Dropping server response to a closed request", "request_id", requestID)
+	}
+}
+
+// send writes a request to the server and returns it so its responses can be awaited.
+// The caller must close the request once it is done with it.
+func (cc *controlConn) send(frameType byte, requestID string, header []byte) (*pendingRequest, error) {
+	request := &pendingRequest{
+		id:        requestID,
+		conn:      cc,
+		responses: make(chan data.ServerResponse, ControlResponseQueueLength),
+		done:      make(chan struct{}),
+	}
+
+	// The request is registered before it is written, the response could come before we get to wait for it
+	cc.mu.Lock()
+	if _, ok := cc.pending[requestID]; ok {
+		cc.mu.Unlock()
+		return nil, fmt.Errorf("request %s is already in flight", requestID)
+	}
+	cc.pending[requestID] = request
+	cc.unanswered = append(cc.unanswered, request)
+	cc.mu.Unlock()
+
+	if err := cc.WriteFrame(frameType, header, nil); err != nil {
+		request.close()
+		return nil, err
+	}
+	return request, nil
+}
+
+// await waits for the next response to the request
+func (request *pendingRequest) await(timeout time.Duration) (data.ServerResponse, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-request.responses:
+		return response, nil
+	case <-request.conn.closed:
+		// Responses read before the connection failed are still handed out
+		select {
+		case response := <-request.responses:
+			return response, nil
+		default:
+		}
+		return data.ServerResponse{}, fmt.Errorf("connection to the server lost: %w", request.conn.err)
+	case <-timer.C:
+		return data.ServerResponse{}, fmt.Errorf("no response to request %s within %s", request.id, timeout)
+	}
+}
+
+// close stops waiting for responses to the request, any that still come are dropped
+func (request *pendingRequest) close() {
+	request.closeOnce.Do(func() { close(request.done) })
+
+	cc := request.conn
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.pending[request.id] == request {
+		delete(cc.pending, request.id)
+	}
+	cc.unanswered = slices.DeleteFunc(cc.unanswered, func(other *pendingRequest) bool { return other == request })
+}
+
+// handleNotification prints an event the server pushed for one of our subscriptions
+func (cc *controlConn) handleNotification(header []byte) {
+	notification, err := DeserializeNotificationStruct(header)
+	if err != nil {
+		cc.log.Warn("Error deserializing notification", "error", err)
+		return
+	}
+
+	cc.log.Info("Notification", "event", notification.Event, "rfc", notification.RFCNumber, "title", notification.RFCTitle,
+		"host", notification.ClientIP, "port", notification.ClientUploadPort, "request_id", notification.RequestID)
+
+	switch notification.Event {
+	case common_helpers.EventAdded:
+		fmt.Printf("\nRFC %s %s is available from %s port %s\n",
+			notification.RFCNumber, notification.RFCTitle, notification.ClientIP, notification.ClientUploadPort)
+	case common_helpers.EventRemoved:
+		fmt.Printf("\nRFC %s %s is no longer available from %s port %s\n",
+			notification.RFCNumber, notification.RFCTitle, notification.ClientIP, notification.ClientUploadPort)
+	default:
+		fmt.Printf("\n%s RFC %s %s\n", notification.Event, notification.RFCNumber, notification.RFCTitle)
+	}
+}
@@ -28,39 +28,24 @@ func DeserializePeerRequest(b []byte) (data.PeerRequest, error) {
 	return peerRequest, nil
 }
 
+// DeserializePeerResponseHeader converts a JSON byte array into a PeerResponseHeader struct
+func DeserializePeerResponseHeader(b []byte) (data.PeerResponseHeader, error) {
+	var peerResponseHeader data.PeerResponseHeader
+	err := json.Unmarshal(b, &peerResponseHeader)
 
-// DeserializePeerResponseData deserializes a byte array into PeerResponseHeader and response data
-func DeserializePeerResponseData(b []byte) (data.PeerResponseHeader, string, error) {
-	var peerResponse data.PeerResponseHeader
-
-	// Find the end of the JSON object by counting braces
-	jsonEnd := -1
-	braceCount := 0
-	for i, char := range b {
-		if char == '{' {
-			braceCount++
-		} else if char == '}' {
-			braceCount--
-			if braceCount == 0 {
-				jsonEnd = i + 1
-				break
-			}
-		}
+	if err != nil {
+		return peerResponseHeader, err
 	}
+	return peerResponseHeader, nil
+}
 
-	if jsonEnd == -1 {
-		return peerResponse, "", json.Unmarshal(b, &peerResponse)
-	}
+// DeserializeNotificationStruct converts a JSON byte array into a NotificationStruct
+func DeserializeNotificationStruct(b []byte) (data.NotificationStruct, error) {
+	var notification data.NotificationStruct
+	err := json.Unmarshal(b, &notification)
 
-	// Unmarshal the JSON header
-	err := json.Unmarshal(b[:jsonEnd], &peerResponse)
 	if err != nil {
-		return peerResponse, "", err
+		return notification, err
 	}
-
-	// Extract the remaining data as string
-	responseData := string(b[jsonEnd:])
-
-	return peerResponse, responseData, nil
+	return notification, nil
 }
-
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
+	"P2P/common-helpers/data"
+)
+
+// fetchChunk is a byte range of the RFC being fetched
+type fetchChunk struct {
+	offset int64
+	length int64
+}
+
+// fetchResult reports the outcome of downloading a chunk from a source
+type fetchResult struct {
+	chunk  fetchChunk
+	source string
+	err    error
+}
+
+// fetchRFC looks up every peer holding an RFC, downloads byte ranges from all of them
+// in parallel, then verifies and saves the reassembled file.
+// A peer failing mid-transfer hands its chunks back to the remaining peers.
+func fetchRFC(conn *controlConn, cmd *Command) error {
+	sources, title, digest, err := lookupSources(conn, cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fetching RFC %s (%s) from %d peers\n", cmd.RFC, title, len(sources))
+
+	if err := os.MkdirAll(RFCsDirectory, 0755); err != nil {
+		return fmt.Errorf("error creating RFCs directory: %w", err)
+	}
+
+	// Hidden so it is never advertised or served while incomplete
+	tempFile, err := os.CreateTemp(RFCsDirectory, ".fetch-*")
+	if err != nil {
+		return fmt.Errorf("error creating download file: %w", err)
+	}
+	defer tempFile.Close()
+	tempFile.Chmod(0644)
+
+	// The first chunk also tells us how big the RFC is and, if the index did not, its digest
+	var size int64
+	var announced string
+	first := fetchChunk{offset: 0, length: FetchChunkSize}
+	for len(sources) > 0 {
+		size, announced, err = fetchRange(tempFile, sources[0], cmd, first, digest)
+		if err == nil {
+			break
+		}
+		cmd.Log.Warn("Peer failed to serve the RFC", "peer", sources[0], "rfc", cmd.RFC, "error", err)
+		sources = sources[1:]
+	}
+	if len(sources) == 0 {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("no peer could serve RFC %s", cmd.RFC)
+	}
+
+	// Every chunk announces the digest of the whole file, a file we cannot verify is not saved
+	if digest == "" {
+		digest = announced
+	}
+	if digest == "" {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("no digest known for RFC %s, refusing to save it unverified", cmd.RFC)
+	}
+
+	// Spread the rest of the file over every peer
+	chunks := []fetchChunk{}
+	for offset := first.length; offset < size; offset += FetchChunkSize {
+		chunks = append(chunks, fetchChunk{offset: offset, length: min(FetchChunkSize, size-offset)})
+	}
+	if err := downloadChunks(tempFile, cmd, sources, chunks, digest); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+
+	// Verify the reassembled file before it lands in the RFCs directory
+	received, err := common_helpers.FileDigest(tempFile.Name())
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("error computing digest: %w", err)
+	}
+	if received != digest {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("%w: received %s, expected %s", ErrDigestMismatch, received, digest)
+	}
+
+	fmt.Printf("Fetched %d bytes of RFC %s\n", size, cmd.RFC)
+	if err := verifySignedRFC(conn, cmd, cmd.RFC, title, tempFile.Name()); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return saveRFCFile(cmd.RFC, title, tempFile.Name())
+}
+
+// lookupSources asks the server which peers hold an RFC and returns their upload addresses.
+// If peers disagree on the content, only the peers serving the most common digest are used.
+func lookupSources(conn *controlConn, cmd *Command) ([]string, string, string, error) {
+	entries, err := lookupHolders(conn, cmd.RequestID, cmd.RFC, cmd.Version)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Never fetch from ourselves
+	holders := []data.ServerResponseData{}
+	digestCount := make(map[string]int)
+	for _, rfcData := range entries {
+		if rfcData.ClientIP == conn.LocalAddr().String() {
+			continue
+		}
+		holders = append(holders, rfcData)
+		digestCount[rfcData.RFCDigest]++
+	}
+	if len(holders) == 0 {
+		return nil, "", "", fmt.Errorf("no other peer holds RFC %s", cmd.RFC)
+	}
+
+	digest := ""
+	for d, count := range digestCount {
+		if count > digestCount[digest] || (count == digestCount[digest] && d > digest) {
+			digest = d
+		}
+	}
+
+	sources := []string{}
+	title := ""
+	for _, rfcData := range holders {
+		if rfcData.RFCDigest == digest {
+			sources = append(sources, uploadAddress(rfcData))
+			title = rfcData.RFCTitle
+		}
+	}
+	return sources, title, digest, nil
+}
+
+// lookupHolders asks the server for every peer holding an RFC, under any title
+func lookupHolders(conn *controlConn, requestID, rfcNumber, version string) ([]data.ServerResponseData, error) {
+	lookupStruct := data.LookUpStruct{
+		RFCNumber:                rfcNumber,
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientApplicationVersion: version,
+		RequestID:                requestID,
+	}
+
+	serialized, err := SerializeLookUpStruct(lookupStruct)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing LookUpStruct: %w", err)
+	}
+	request, err := conn.send(common_helpers.LookupStructIndex, requestID, serialized)
+	if err != nil {
+		return nil, fmt.Errorf("error sending LOOKUP request: %w", err)
+	}
+	defer request.close()
+
+	serverResponse, err := readServerResponse(request)
+	if err != nil {
+		return nil, fmt.Errorf("error reading server response: %w", err)
+	}
+	if serverResponse.Header.ResponseCode != StatusOK {
+		return nil, fmt.Errorf("LOOKUP of RFC %s failed: %d %s", rfcNumber,
+			serverResponse.Header.ResponseCode, serverResponse.Header.ResponsePhrase)
+	}
+	return serverResponse.Data, nil
+}
+
+// downloadChunks downloads chunks from the sources in parallel, one worker per source,
+// writing each chunk at its offset in file. Every chunk must come from the file with the given digest.
+func downloadChunks(file *os.File, cmd *Command, sources []string, chunks []fetchChunk, digest string) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	jobs := make(chan fetchChunk, len(chunks))
+	for _, chunk := range chunks {
+		jobs <- chunk
+	}
+	results := make(chan fetchResult)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, source := range sources {
+		go func(source string) {
+			for {
+				select {
+				case <-stop:
+					return
+				case chunk := <-jobs:
+					_, _, err := fetchRange(file, source, cmd, chunk, digest)
+					select {
+					case results <- fetchResult{chunk: chunk, source: source, err: err}:
+					case <-stop:
+						return
+					}
+					// A failing peer is dropped, its chunk goes back to the others
+					if err != nil {
+						return
+					}
+				}
+			}
+		}(source)
+	}
+
+	missing := len(chunks)
+	alive := len(sources)
+	for missing > 0 {
+		if alive == 0 {
+			return fmt.Errorf("every peer failed, %d chunks of RFC %s missing", missing, cmd.RFC)
+		}
+
+		result := <-results
+		if result.err != nil {
+			cmd.Log.Warn("Peer failed to serve a chunk", "peer", result.source, "rfc", cmd.RFC, "offset", result.chunk.offset, "error", result.err)
+			jobs <- result.chunk
+			alive--
+			continue
+		}
+		missing--
+	}
+	return nil
+}
+
+// fetchRange downloads a byte range of an RFC from a peer into file at the same offset.
+// It returns the size and digest of the whole RFC as reported by the peer, which must match digest unless it is empty.
+func fetchRange(file *os.File, source string, cmd *Command, chunk fetchChunk, digest string) (int64, string, error) {
+	rawConn, err := dial(source, PeerResponseTimeout)
+	if err != nil {
+		return 0, "", fmt.Errorf("error connecting to peer: %w", err)
+	}
+	defer rawConn.Close()
+	conn := codec.NewConn(rawConn, wireCodec)
+
+	request := data.PeerRequest{
+		RFCNumber:   cmd.RFC,
+		Version:     ApplicationVersion,
+		PeerIP:      conn.LocalAddr().String(),
+		PeerOS:      runtime.GOOS,
+		RangeStart:  fmt.Sprintf("%d", chunk.offset),
+		RangeLength: fmt.Sprintf("%d", chunk.length),
+		RequestID:   cmd.RequestID,
+	}
+	serializedRequest, err := SerializePeerRequest(request)
+	if err != nil {
+		return 0, "", fmt.Errorf("error serializing peer request: %w", err)
+	}
+	if err := conn.WriteFrame(common_helpers.PeerRequestIndex, serializedRequest, nil); err != nil {
+		return 0, "", fmt.Errorf("error sending GET request: %w", err)
+	}
+
+	peerResponseHeader, bodyLength, err := readPeerResponse(conn, cmd.Log)
+	if err != nil {
+		return 0, "", err
+	}
+	// An empty RFC has no range to send, the peer answers the first chunk with the whole file
+	empty := peerResponseHeader.Status == StatusOK && bodyLength == 0 && chunk.offset == 0
+	if peerResponseHeader.Status != StatusPartialContent && !empty {
+		io.Copy(io.Discard, conn.BodyReader(bodyLength))
+		return 0, "", fmt.Errorf("peer answered %d %s", peerResponseHeader.Status, peerResponseHeader.Phrase)
+	}
+
+	// A peer serving another version of the RFC would corrupt the reassembled file
+	if digest != "" && peerResponseHeader.ContentDigest != digest {
+		io.Copy(io.Discard, conn.BodyReader(bodyLength))
+		return 0, "", fmt.Errorf("%w: peer announced %q, expected %s", ErrDigestMismatch, peerResponseHeader.ContentDigest, digest)
+	}
+	if empty {
+		return 0, peerResponseHeader.ContentDigest, nil
+	}
+
+	first, last, size, err := parseContentRange(peerResponseHeader.ContentRange)
+	if err != nil {
+		return 0, "", err
+	}
+	if first != chunk.offset || last-first+1 != bodyLength {
+		return 0, "", fmt.Errorf("peer sent range %s, asked for %d bytes at %d", peerResponseHeader.ContentRange, chunk.length, chunk.offset)
+	}
+
+	received, err := copyChunks(io.NewOffsetWriter(file, chunk.offset), conn.BodyReader(bodyLength), bodyLength, conn.SetReadDeadline)
+	downloadBytes.Add(float64(received))
+	if err != nil {
+		return 0, "", err
+	}
+	return size, peerResponseHeader.ContentDigest, nil
+}
+
+// uploadAddress returns the address of the upload server of a peer in a LOOKUP result
+func uploadAddress(rfcData data.ServerResponseData) string {
+	host, _, err := net.SplitHostPort(rfcData.ClientIP)
+	if err != nil {
+		host = rfcData.ClientIP
+	}
+	return net.JoinHostPort(host, rfcData.ClientUploadPort)
+}
@@ -2,11 +2,36 @@ package main
 
 import (
 	common_helpers "P2P/common-helpers"
+	"crypto/tls"
 	"math/rand"
+	"net"
 	"strconv"
+	"time"
 	"unicode"
 )
 
+// dial connects to the server or another peer, over TLS when it is configured.
+// A zero timeout waits as long as the operating system does.
+func dial(address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if tlsClientConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", address, tlsClientConfig)
+	}
+	return dialer.Dial("tcp", address)
+}
+
+// listen opens the upload listener, over TLS when it is configured
+func listen(port string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+	if tlsServerConfig != nil {
+		return tls.NewListener(listener, tlsServerConfig), nil
+	}
+	return listener, nil
+}
+
 // Function to get a random Port which will be used for uploading files when requested from other server
 func getRandomUploadPort() (string, error) {
 	port := strconv.Itoa(rand.Intn(3001) + 4000)
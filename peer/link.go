@@ -0,0 +1,205 @@
+// This file keeps the peer connected to the server, reconnecting and registering our RFCs again when the connection is lost
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/data"
+)
+
+// serverLink supervises our session with the server.
+// The server forgets everything we published and subscribed to when the session ends,
+// so every new connection is authenticated, registers ./RFCs and subscribes again.
+type serverLink struct {
+	uploadPort string
+
+	// mu guards conn, the connection commands are sent on, and subscriptions
+	mu   sync.Mutex
+	conn *controlConn
+
+	// subscriptions are the selections the server accepted a SUBSCRIBE for
+	subscriptions []data.SubscribeStruct
+
+	// stopping is closed when the peer shuts down, ending the supervision
+	stopping chan struct{}
+}
+
+// dialServerLink opens the first session with the server, a peer that cannot reach it at startup gives up
+func dialServerLink(uploadPort string) (*serverLink, error) {
+	sl := &serverLink{
+		uploadPort: uploadPort,
+		stopping:   make(chan struct{}),
+	}
+	conn, err := sl.connect()
+	if err != nil {
+		return nil, err
+	}
+	sl.conn = conn
+	go sl.supervise()
+	return sl, nil
+}
+
+// connect opens a session with the server: it connects, authenticates, registers our RFCs, subscribes again and starts the heartbeats
+func (sl *serverLink) connect() (*controlConn, error) {
+	conn, peerID, err := connectToServer()
+	if err != nil {
+		return nil, err
+	}
+
+	// Responses and notifications are told apart from here on
+	serverConn := newControlConn(conn, peerID)
+
+	// Authenticate before publishing, anonymous peers can only look up and list
+	if authName != "" {
+		if err := authenticate(serverConn); err != nil {
+			serverConn.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+		serverConn.log.Info("Authenticated", "name", authName)
+	}
+
+	// The directory is read again, it holds whatever we downloaded since
+	if err := loadRFCFiles(); err != nil {
+		serverConn.Close()
+		return nil, fmt.Errorf("failed to load RFC files: %w", err)
+	}
+	if err := registerRFCs(serverConn, sl.uploadPort); err != nil {
+		serverConn.Close()
+		return nil, fmt.Errorf("failed to register RFCs: %w", err)
+	}
+	serverConn.log.Info("All RFCs registered successfully", "rfcs", len(serverConn.published))
+	if err := sl.resubscribe(serverConn); err != nil {
+		serverConn.Close()
+		return nil, fmt.Errorf("failed to subscribe again: %w", err)
+	}
+
+	// Keep our entries in the index while we are idle
+	go sendHeartbeats(serverConn, sl.uploadPort)
+	return serverConn, nil
+}
+
+// subscribed records a subscription the server accepted so it is sent again on the next sessions
+func (sl *serverLink) subscribed(subscribeStruct data.SubscribeStruct) {
+	// Only the selection is kept, the address and request ID are those of the session sending it
+	subscribeStruct.ClientIP = ""
+	subscribeStruct.RequestID = ""
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if !slices.Contains(sl.subscriptions, subscribeStruct) {
+		sl.subscriptions = append(sl.subscriptions, subscribeStruct)
+	}
+}
+
+// resubscribe sends our subscriptions on a new session, the events of the time we were away are not replayed
+func (sl *serverLink) resubscribe(conn *controlConn) error {
+	sl.mu.Lock()
+	subscriptions := slices.Clone(sl.subscriptions)
+	sl.mu.Unlock()
+
+	for _, subscribeStruct := range subscriptions {
+		subscribeStruct.ClientIP = conn.LocalAddr().String()
+		subscribeStruct.RequestID = common_helpers.NewRequestID()
+		logger := conn.log.With("request_id", subscribeStruct.RequestID, "number", subscribeStruct.RFCNumber,
+			"from", subscribeStruct.RFCNumberFrom, "to", subscribeStruct.RFCNumberTo,
+			"title", subscribeStruct.RFCTitle, "title_match", subscribeStruct.TitleMatch)
+
+		serialized, err := SerializeSubscribeStruct(subscribeStruct)
+		if err != nil {
+			return fmt.Errorf("error serializing SubscribeStruct: %w", err)
+		}
+		response, err := roundTrip(conn, common_helpers.SubscribeIndex, subscribeStruct.RequestID, serialized)
+		if err != nil {
+			return err
+		}
+		if response.Header.ResponseCode != StatusOK {
+			logger.Warn("Server refused the subscription again", "code", response.Header.ResponseCode, "phrase", response.Header.ResponsePhrase)
+			continue
+		}
+		logger.Info("Subscribed again")
+	}
+	return nil
+}
+
+// current returns the connection to send commands on.
+// While we are reconnecting it is the lost one, and commands fail rather than wait.
+func (sl *serverLink) current() *controlConn {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.conn
+}
+
+// supervise waits for the connection to be lost and replaces it, until the peer shuts down
+func (sl *serverLink) supervise() {
+	for {
+		lost := sl.current()
+		select {
+		case <-lost.closed:
+		case <-sl.stopping:
+			return
+		}
+
+		// Closing it also stops the heartbeats sent on it
+		lost.Close()
+		lost.log.Warn("Lost the connection to the server, reconnecting", "error", lost.err)
+
+		conn, ok := sl.reconnect()
+		if !ok {
+			return
+		}
+		sl.mu.Lock()
+		// The peer may have shut down while we were connecting
+		select {
+		case <-sl.stopping:
+			sl.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		sl.conn = conn
+		sl.mu.Unlock()
+		serverReconnects.Inc()
+	}
+}
+
+// reconnect tries to open a new session until it succeeds, waiting exponentially longer between attempts.
+// It gives up only when the peer shuts down.
+func (sl *serverLink) reconnect() (*controlConn, bool) {
+	backoff := ReconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		// Peers that lost the server together do not all come back at the same moment
+		wait := backoff/2 + rand.N(backoff/2)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-sl.stopping:
+			timer.Stop()
+			return nil, false
+		}
+
+		conn, err := sl.connect()
+		if err == nil {
+			conn.log.Info("Reconnected to the server", "attempts", attempt)
+			return conn, true
+		}
+		peerLog.Warn("Reconnect attempt failed", "attempt", attempt, "error", err)
+		backoff = min(backoff*2, ReconnectMaxBackoff)
+	}
+}
+
+// Close ends the supervision and the session with the server
+func (sl *serverLink) Close() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	select {
+	case <-sl.stopping:
+	default:
+		close(sl.stopping)
+	}
+	sl.conn.Close()
+}
@@ -2,17 +2,26 @@ package main
 
 import (
 	"bufio"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
 	"P2P/common-helpers/data"
 
 	"github.com/joho/godotenv"
@@ -22,124 +31,432 @@ var (
 	serverPort    string
 	serverAddress string
 	fileNames     []string
+
+	// peerLog is the structured logger every command and upload logger derives from
+	peerLog *slog.Logger
+
+	// wireCodec is the encoding spoken to the server and to other peers
+	wireCodec codec.Codec
+
+	// connectionMode is how the control connection to the server is set up, single-port or dedicated port
+	connectionMode string
+
+	// tlsClientConfig and tlsServerConfig secure the connections we make and accept, nil for plain TCP
+	tlsClientConfig *tls.Config
+	tlsServerConfig *tls.Config
+
+	// authName is who we authenticate as, with either the shared-secret authToken or the ed25519 authKey.
+	// Without a name we stay anonymous.
+	authName  string
+	authToken string
+	authKey   ed25519.PrivateKey
+
+	// trustedPublishers are the keys RFC manifests are verified with, nil when downloads are not verified
+	trustedPublishers map[string]ed25519.PublicKey
+
+	// heartbeatInterval is how often a heartbeat renews our lease on the server index
+	heartbeatInterval time.Duration
+
+	// rfcWatchInterval is how often the RFCs directory is checked for changes, 0 when it is not watched
+	rfcWatchInterval time.Duration
 )
 
 // loadConfig loads configuration from environment variables
 func loadConfig() {
-	if err := godotenv.Load("../.env"); err != nil {
-		log.Println("Warning: .env file not found in parent directory")
+	envErr := godotenv.Load("../.env")
+
+	// Every log line goes through the structured logger, at the level asked for
+	var err error
+	peerLog, err = common_helpers.NewLogger("peer")
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	if envErr != nil {
+		peerLog.Warn(".env file not found in parent directory")
 	}
 
 	serverPort = os.Getenv("SERVER_CONNECTIONS_PORT")
 	if serverPort == "" {
-		log.Printf("Using default server port %s", DefaultServerPort)
+		peerLog.Info("Using default server port", "port", DefaultServerPort)
 		serverPort = DefaultServerPort
 	}
 
 	serverAddress = os.Getenv("SERVER_IP_ADDRESS")
 	if serverAddress == "" {
-		log.Println("Using default server address: localhost")
+		peerLog.Info("Using default server address", "address", "localhost")
 		serverAddress = "localhost"
 	}
+
+	encoding := os.Getenv("PROTOCOL_ENCODING")
+	if encoding == "" {
+		encoding = DefaultProtocolEncoding
+	}
+	wireCodec, err = codec.ByName(encoding)
+	if err != nil {
+		peerLog.Warn("Invalid PROTOCOL_ENCODING, using the default", "error", err, "default", DefaultProtocolEncoding)
+		wireCodec, _ = codec.ByName(DefaultProtocolEncoding)
+	}
+	peerLog.Info("Using protocol encoding", "encoding", wireCodec.Name())
+
+	connectionMode = os.Getenv("CONNECTION_MODE")
+	switch connectionMode {
+	case common_helpers.ConnectionModeSingle, common_helpers.ConnectionModeDedicated:
+	case "":
+		connectionMode = common_helpers.ConnectionModeSingle
+	default:
+		peerLog.Warn("Unknown CONNECTION_MODE, using the default", "value", connectionMode, "default", common_helpers.ConnectionModeSingle)
+		connectionMode = common_helpers.ConnectionModeSingle
+	}
+
+	// With TLS every connection is secured, so a peer needs the CA and a certificate for its upload server
+	tlsSettings, err := common_helpers.LoadTLSSettings()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if tlsSettings.Enabled() {
+		tlsClientConfig, err = tlsSettings.ClientConfig()
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		tlsServerConfig, err = tlsSettings.ServerConfig()
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		peerLog.Info("TLS enabled", "client_auth", tlsSettings.ClientAuth)
+	}
+
+	// Credentials are only needed when the server requires publishers to authenticate
+	authName = os.Getenv("AUTH_NAME")
+	authToken = os.Getenv("AUTH_TOKEN")
+	if keyFile := os.Getenv("AUTH_KEY_FILE"); keyFile != "" {
+		if authToken != "" {
+			log.Fatalf("Set either AUTH_TOKEN or AUTH_KEY_FILE, not both")
+		}
+		authKey, err = common_helpers.LoadAuthKey(keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load AUTH_KEY_FILE: %v", err)
+		}
+	}
+	if authName != "" && authToken == "" && authKey == nil {
+		log.Fatalf("AUTH_NAME needs AUTH_TOKEN or AUTH_KEY_FILE")
+	}
+
+	// Downloads are only saved if a trusted publisher signed them
+	if keysFile := os.Getenv("TRUSTED_KEYS_FILE"); keysFile != "" {
+		trustedPublishers, err = common_helpers.LoadPublicKeys(keysFile)
+		if err != nil {
+			log.Fatalf("Failed to load TRUSTED_KEYS_FILE: %v", err)
+		}
+		peerLog.Info("Downloads must be signed by a trusted publisher", "trusted_publishers", len(trustedPublishers))
+	}
+
+	heartbeatInterval = DefaultHeartbeatInterval
+	if value := os.Getenv("HEARTBEAT_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			peerLog.Warn("Invalid HEARTBEAT_INTERVAL, using the default", "value", value, "default", DefaultHeartbeatInterval)
+		} else {
+			heartbeatInterval = parsed
+		}
+	}
+
+	rfcWatchInterval = DefaultRFCWatchInterval
+	switch value := os.Getenv("RFC_WATCH_INTERVAL"); value {
+	case "":
+	case "off":
+		rfcWatchInterval = 0
+	default:
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			peerLog.Warn("Invalid RFC_WATCH_INTERVAL, using the default", "value", value, "default", DefaultRFCWatchInterval)
+		} else {
+			rfcWatchInterval = parsed
+		}
+	}
 }
 
-// connectToServer establishes initial connection and gets dedicated port
-func connectToServer() (net.Conn, error) {
-	// Initial connection to get dedicated port assignment
-	initialConn, err := net.Dial("tcp", serverAddress+":"+serverPort)
+// connectToServer connects to the server and returns the connection carrying the protocol, with the peer ID
+// the server assigned to the session. In single-port mode that is the connection we opened, in dedicated mode
+// we reconnect on the port the server assigns.
+func connectToServer() (*codec.Conn, string, error) {
+	initialConn, err := dial(net.JoinHostPort(serverAddress, serverPort), 0)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to server: %w", err)
+		return nil, "", fmt.Errorf("failed to connect to server: %w", err)
+	}
+
+	// Tell the server which mode we want
+	if _, err := fmt.Fprintf(initialConn, "%s %s\n", common_helpers.HandshakeHello, connectionMode); err != nil {
+		initialConn.Close()
+		return nil, "", fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	if connectionMode == common_helpers.ConnectionModeSingle {
+		assignedID, err := common_helpers.ReadHandshakeLine(initialConn)
+		if err != nil {
+			initialConn.Close()
+			return nil, "", fmt.Errorf("failed to read peer ID: %w", err)
+		}
+		peerID := strings.TrimSpace(assignedID)
+		peerLog.Info("Server assigned peer ID", "peer_id", peerID)
+		return codec.NewConn(initialConn, wireCodec), peerID, nil
 	}
 	defer initialConn.Close()
 
-	// Read dedicated port from server
-	dedicatedPort, err := bufio.NewReader(initialConn).ReadString('\n')
+	// Read dedicated port and peer ID from server
+	assignment, err := common_helpers.ReadHandshakeLine(initialConn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read dedicated port: %w", err)
+		return nil, "", fmt.Errorf("failed to read dedicated port: %w", err)
 	}
 
-	dedicatedPort = strings.TrimSpace(dedicatedPort)
-	log.Printf("Server assigned dedicated port: %s", dedicatedPort)
+	fields := strings.Fields(assignment)
+	if len(fields) == 0 {
+		return nil, "", fmt.Errorf("server did not assign a dedicated port")
+	}
+	dedicatedPort := fields[0]
+	peerLog.Info("Server assigned dedicated port", "port", dedicatedPort)
+	// Servers predating peer IDs only send the port
+	peerID := ""
+	if len(fields) > 1 {
+		peerID = fields[1]
+		peerLog.Info("Server assigned peer ID", "peer_id", peerID)
+	}
 
 	// Connect to dedicated port
-	dedicatedConn, err := net.Dial("tcp", serverAddress+":"+dedicatedPort)
+	dedicatedConn, err := dial(net.JoinHostPort(serverAddress, dedicatedPort), 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to dedicated port: %w", err)
+	}
+
+	return codec.NewConn(dedicatedConn, wireCodec), peerID, nil
+}
+
+// authenticate proves our identity to the server so it lets us publish.
+// Keys sign the challenge of our session, tokens are sent as they are and should only be used over TLS.
+func authenticate(conn *controlConn) error {
+	authStruct := data.AuthStruct{
+		Name:                     authName,
+		Method:                   common_helpers.AuthMethodToken,
+		Credential:               authToken,
+		ClientApplicationVersion: ApplicationVersion,
+		RequestID:                common_helpers.NewRequestID(),
+	}
+	if authKey != nil {
+		authStruct.Method = common_helpers.AuthMethodEd25519
+		authStruct.Credential = base64.StdEncoding.EncodeToString(ed25519.Sign(authKey, common_helpers.AuthChallenge(conn.peerID)))
+	}
+
+	serialized, err := SerializeAuthStruct(authStruct)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to dedicated port: %w", err)
+		return fmt.Errorf("error serializing AuthStruct: %w", err)
 	}
+	request, err := conn.send(common_helpers.AuthIndex, authStruct.RequestID, serialized)
+	if err != nil {
+		return fmt.Errorf("error sending AUTH request: %w", err)
+	}
+	defer request.close()
 
-	return dedicatedConn, nil
+	response, err := readServerResponse(request)
+	if err != nil {
+		return err
+	}
+	if response.Header.ResponseCode != StatusOK {
+		return fmt.Errorf("server refused %s: %d %s", authName, response.Header.ResponseCode, response.Header.ResponsePhrase)
+	}
+	return nil
 }
 
 // loadRFCFiles loads available RFC files from the RFCs directory
 func loadRFCFiles() error {
-	entries, err := os.ReadDir("./RFCs")
+	entries, err := os.ReadDir(RFCsDirectory)
 	if err != nil {
 		return fmt.Errorf("error reading RFC directory: %w", err)
 	}
 
+	// The list is built again every time we register with the server
+	fileNames = nil
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if isServedFile(entry) {
 			fileNames = append(fileNames, entry.Name())
-			log.Printf("Found RFC file: %s", entry.Name())
+			peerLog.Debug("Found RFC file", "file", entry.Name())
 		}
 	}
 
 	return nil
 }
 
-// registerRFCs registers all available RFCs with the server
-func registerRFCs(conn net.Conn, uploadPort string) error {
-	reader := bufio.NewReader(conn)
+// isServedFile checks if an entry of the RFCs directory is an RFC we serve.
+// Hidden files are downloads still in progress.
+func isServedFile(entry os.DirEntry) bool {
+	return !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".")
+}
 
-	for _, filename := range fileNames {
-		// Parse filename format: Number_title.txt
-		parts := strings.Split(filename, "_")
-		if len(parts) < 2 {
-			log.Printf("Skipping invalid filename format: %s", filename)
-			continue
+// localRFC is a file of the RFCs directory as we announce it to the server
+type localRFC struct {
+	Number  string
+	Title   string
+	Path    string
+	Digest  string
+	Size    int64
+	ModTime time.Time
+}
+
+// readLocalRFC reads what the server is told about a file of the RFCs directory, named Number_title.txt
+func readLocalRFC(filename string) (localRFC, error) {
+	parts := strings.Split(filename, "_")
+	if len(parts) < 2 {
+		return localRFC{}, fmt.Errorf("invalid filename format: %s", filename)
+	}
+
+	rfc := localRFC{
+		Number: parts[0],
+		Title:  strings.TrimSuffix(parts[1], ".txt"),
+		Path:   RFCsDirectory + "/" + filename,
+	}
+	info, err := os.Stat(rfc.Path)
+	if err != nil {
+		return localRFC{}, fmt.Errorf("error reading RFC file: %w", err)
+	}
+	rfc.Size = info.Size()
+	rfc.ModTime = info.ModTime()
+
+	rfc.Digest, err = digestOf(rfc.Path, info)
+	if err != nil {
+		return localRFC{}, fmt.Errorf("cannot compute digest: %w", err)
+	}
+	return rfc, nil
+}
+
+// fileDigest is the digest of a file as it was when it was hashed
+type fileDigest struct {
+	size    int64
+	modTime time.Time
+	digest  string
+}
+
+var (
+	// fileDigests holds the digest of every file of the RFCs directory we hashed, by path.
+	// A file is only hashed again once its size or modification time changed.
+	fileDigests   = make(map[string]fileDigest)
+	fileDigestsMu sync.Mutex
+)
+
+// digestOf returns the digest of the file at path, info is what a stat of it just returned
+func digestOf(path string, info os.FileInfo) (string, error) {
+	fileDigestsMu.Lock()
+	cached, ok := fileDigests[path]
+	fileDigestsMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.digest, nil
+	}
+
+	digest, err := common_helpers.FileDigest(path)
+	if err != nil {
+		return "", err
+	}
+
+	fileDigestsMu.Lock()
+	defer fileDigestsMu.Unlock()
+	fileDigests[path] = fileDigest{size: info.Size(), modTime: info.ModTime(), digest: digest}
+	return digest, nil
+}
+
+// addStruct builds the ADD request announcing the RFC on a connection
+func (rfc localRFC) addStruct(conn *controlConn, uploadPort string) data.AddStruct {
+	return data.AddStruct{
+		RFCNumber:                rfc.Number,
+		RFCTitle:                 rfc.Title,
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientUploadPort:         uploadPort,
+		ClientApplicationVersion: ApplicationVersion,
+		RFCDigest:                rfc.Digest,
+		RFCManifest:              signManifest(rfc.Number, rfc.Title, rfc.Path, rfc.Digest),
+		RequestID:                common_helpers.NewRequestID(),
+	}
+}
+
+// sendHeartbeats keeps our lease on the server index alive until the connection fails.
+// The server never answers heartbeats, so they do not disturb the command loop.
+func sendHeartbeats(conn *controlConn, uploadPort string) {
+	heartbeat := data.HeartbeatStruct{
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientUploadPort:         uploadPort,
+		ClientApplicationVersion: ApplicationVersion,
+	}
+	serialized, err := SerializeHeartbeatStruct(heartbeat)
+	if err != nil {
+		conn.log.Error("Error serializing HeartbeatStruct", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := conn.WriteFrame(common_helpers.HeartbeatIndex, serialized, nil); err != nil {
+			conn.log.Warn("Error sending heartbeat, stopping heartbeats", "error", err)
+			return
 		}
+	}
+}
 
-		rfcNumber := parts[0]
-		rfcTitle := strings.TrimSuffix(parts[1], ".txt")
+// registerRFCs registers all available RFCs with the server, recording the ones it accepted as published on the connection.
+// Every ADD is sent before the first response is read, so registering many RFCs takes about one round trip.
+func registerRFCs(conn *controlConn, uploadPort string) error {
+	type registration struct {
+		request  *pendingRequest
+		filename string
+		rfc      localRFC
+	}
+	registrations := []registration{}
+	defer func() {
+		for _, r := range registrations {
+			r.request.close()
+		}
+	}()
 
-		addStruct := data.AddStruct{
-			RFCNumber:                rfcNumber,
-			RFCTitle:                 rfcTitle,
-			ClientIP:                 conn.LocalAddr().String(),
-			ClientUploadPort:         uploadPort,
-			ClientApplicationVersion: ApplicationVersion,
+	for _, filename := range fileNames {
+		rfc, err := readLocalRFC(filename)
+		if err != nil {
+			conn.log.Warn("Skipping RFC", "file", filename, "error", err)
+			continue
 		}
 
+		addStruct := rfc.addStruct(conn, uploadPort)
 		serialized, err := SerializeAddStruct(addStruct)
 		if err != nil {
-			return fmt.Errorf("error serializing RFC %s: %w", rfcNumber, err)
+			return fmt.Errorf("error serializing RFC %s: %w", rfc.Number, err)
 		}
 
-		message := append([]byte{byte(common_helpers.AddStructIndex)}, serialized...)
-		message = append(message, '\n')
-
-		if _, err := conn.Write(message); err != nil {
-			return fmt.Errorf("error sending RFC %s: %w", rfcNumber, err)
+		request, err := conn.send(common_helpers.AddStructIndex, addStruct.RequestID, serialized)
+		if err != nil {
+			return fmt.Errorf("error sending RFC %s: %w", rfc.Number, err)
 		}
+		registrations = append(registrations, registration{request, filename, rfc})
+	}
 
-		// Read and consume the server response
-		_, err = readServerResponse(reader, conn)
+	// Read and consume the server responses, matched to their RFC by request ID
+	for _, r := range registrations {
+		response, err := readServerResponse(r.request)
 		if err != nil {
-			log.Printf("Warning: Failed to read response for RFC %s: %v", rfcNumber, err)
+			conn.log.Warn("Failed to read response for RFC", "rfc", r.rfc.Number, "error", err)
+			continue
+		}
+		if response.Header.ResponseCode != StatusOK {
+			conn.log.Warn("Server refused RFC", "rfc", r.rfc.Number, "code", response.Header.ResponseCode, "phrase", response.Header.ResponsePhrase)
+			continue
 		}
 
-		log.Printf("Registered RFC %s: %s", rfcNumber, rfcTitle)
+		conn.published[r.filename] = r.rfc
+		conn.log.Info("Registered RFC", "rfc", r.rfc.Number, "title", r.rfc.Title)
 	}
 
 	return nil
 }
 
 // startCommandLoop starts the interactive command loop
-func startCommandLoop(conn net.Conn) {
+func startCommandLoop(link *serverLink) {
 	scanner := bufio.NewScanner(os.Stdin)
-	reader := bufio.NewReader(conn)
 	for {
-		fmt.Print("\nEnter command (ADD/LOOKUP/LIST/GET): ")
+		fmt.Print("\nEnter command (ADD/REMOVE/LOOKUP/LIST/SEARCH/SUBSCRIBE/GET/FETCH): ")
 
 		if !scanner.Scan() {
 			break
@@ -147,48 +464,74 @@ func startCommandLoop(conn net.Conn) {
 
 		input := scanner.Text()
 
-
-		if err := executeCommand(conn, input, reader); err != nil {
+		if err := executeCommand(link, input); err != nil {
 			fmt.Printf("Error: %v\n", err)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Printf("Scanner error: %v", err)
+		peerLog.Error("Scanner error", "error", err)
 	}
 }
 
 // sendErrorResponse sends an error response to the client
-func sendErrorResponse(conn net.Conn, code int, phrase string) error {
+func sendErrorResponse(conn *codec.Conn, logger *slog.Logger, request data.PeerRequest, code int, phrase string) error {
+	responseHeader := data.PeerResponseHeader{
+		PeerApplicationVersion:  ApplicationVersion,
+		Status:                  code,
+		Phrase:                  phrase,
+		CurrentDateandTime:      time.Now().Format(time.RFC3339),
+		OS:                      runtime.GOOS,
+		LastModifiedDateandTime: "",
+		ContentLength:           "0",
+		ContentType:             "text/plain",
+		RequestID:               request.RequestID,
+	}
+
+	serialized, err := SerializePeerResponseHeader(responseHeader)
+	if err != nil {
+		return fmt.Errorf("error serializing response: %w", err)
+	}
+
+	uploadsTotal.Inc(strconv.Itoa(code))
+	logger.Info("Refusing GET request", "rfc", request.RFCNumber, "status", code, "phrase", phrase)
+	return conn.WriteFrame(common_helpers.PeerResponseIndex, serialized, nil)
+}
+
+// sendRangeNotSatisfiable tells the client its range lies outside the file, and how big the file is
+func sendRangeNotSatisfiable(conn *codec.Conn, logger *slog.Logger, request data.PeerRequest, size int64) error {
 	responseHeader := data.PeerResponseHeader{
-		PeerApplicationVersion:    ApplicationVersion,
-		Status:                    code,
-		Phrase:                    phrase,
-		CurrentDateandTime:        time.Now().Format(time.RFC3339),
-		OS:                        runtime.GOOS,
-		LastModifiedDateandTime:   "",
-		ContentLength:             "0",
-		ContentType:               "text/plain",
+		PeerApplicationVersion: ApplicationVersion,
+		Status:                 StatusRangeNotSatisfiable,
+		Phrase:                 "Range Not Satisfiable",
+		CurrentDateandTime:     time.Now().Format(time.RFC3339),
+		OS:                     runtime.GOOS,
+		ContentLength:          "0",
+		ContentType:            "text/plain",
+		ContentRange:           fmt.Sprintf("bytes */%d", size),
+		RequestID:              request.RequestID,
 	}
 
-	serialized, err := SerializePeerResponse(responseHeader,"")
+	serialized, err := SerializePeerResponseHeader(responseHeader)
 	if err != nil {
 		return fmt.Errorf("error serializing response: %w", err)
 	}
 
-	serialized = append(serialized, '\n')
-	_, err = conn.Write(serialized)
-	return err
+	uploadsTotal.Inc(strconv.Itoa(StatusRangeNotSatisfiable))
+	logger.Info("Refusing GET request", "rfc", request.RFCNumber, "status", StatusRangeNotSatisfiable, "size", size)
+	return conn.WriteFrame(common_helpers.PeerResponseIndex, serialized, nil)
 }
 
-// sendSuccessResponse sends a success response with data to the client
-func sendSuccessResponse(conn net.Conn, rfcNumber string) error {
+// sendSuccessResponse sends a success response with data to the client.
+// If the request names a byte range only that range is sent, as 206 Partial Content.
+func sendSuccessResponse(conn *codec.Conn, logger *slog.Logger, request data.PeerRequest) error {
+	rfcNumber := request.RFCNumber
 
 	// Reload RFC files to include any newly added RFCs
-	entries, err := os.ReadDir("./RFCs")
+	entries, err := os.ReadDir(RFCsDirectory)
 	if err != nil {
-		log.Printf("Error reading RFC directory: %v", err)
-		return sendErrorResponse(conn, 500, "Internal Server Error")
+		logger.Error("Error reading RFC directory", "error", err)
+		return sendErrorResponse(conn, logger, request, 500, "Internal Server Error")
 	}
 
 	// Find the RFC file with the given number
@@ -198,7 +541,7 @@ func sendSuccessResponse(conn net.Conn, rfcNumber string) error {
 		if !entry.IsDir() {
 			filename := entry.Name()
 			if strings.HasPrefix(filename, rfcNumber+"_") {
-				rfcFilePath = "./RFCs/" + filename
+				rfcFilePath = RFCsDirectory + "/" + filename
 				// Extract title from filename: <RFC_NUMBER>_<TITLE>.txt
 				// Remove the RFC number prefix and .txt suffix
 				titleWithExt := strings.TrimPrefix(filename, rfcNumber+"_")
@@ -209,20 +552,27 @@ func sendSuccessResponse(conn net.Conn, rfcNumber string) error {
 	}
 
 	if rfcFilePath == "" {
-		return sendErrorResponse(conn, 404, "RFC Not Found")
+		return sendErrorResponse(conn, logger, request, 404, "RFC Not Found")
+	}
+
+	// Open the RFC file, its content is streamed to the socket in chunks
+	rfcFile, err := os.Open(rfcFilePath)
+	if err != nil {
+		return sendErrorResponse(conn, logger, request, 500, "Internal Server Error")
 	}
+	defer rfcFile.Close()
 
-	// Get file info for last modified time
-	fileInfo, err := os.Stat(rfcFilePath)
+	// Get file info for last modified time and content length
+	fileInfo, err := rfcFile.Stat()
 	if err != nil {
-		fmt.Println("Error getting file info: ", err)
-		return sendErrorResponse(conn, 404, "RFC Not Found")
+		logger.Error("Error getting file info", "error", err)
+		return sendErrorResponse(conn, logger, request, 404, "RFC Not Found")
 	}
 
-	// Read the RFC file content
-	responseData, err := os.ReadFile(rfcFilePath)
+	// Digest the file so the downloader can verify what it receives, every range of a FETCH is answered from the cache
+	digest, err := digestOf(rfcFilePath, fileInfo)
 	if err != nil {
-		return sendErrorResponse(conn, 500, "Internal Server Error")
+		return sendErrorResponse(conn, logger, request, 500, "Internal Server Error")
 	}
 
 	responseHeader := data.PeerResponseHeader{
@@ -232,95 +582,146 @@ func sendSuccessResponse(conn net.Conn, rfcNumber string) error {
 		CurrentDateandTime:      time.Now().Format(time.RFC3339),
 		OS:                      runtime.GOOS,
 		LastModifiedDateandTime: fileInfo.ModTime().Format(time.RFC3339),
-		ContentLength:           fmt.Sprintf("%d", len(responseData)),
+		ContentLength:           fmt.Sprintf("%d", fileInfo.Size()),
 		ContentType:             "text/plain",
 		RFCTitle:                rfcTitle,
+		ContentDigest:           digest,
+		RequestID:               request.RequestID,
+	}
+
+	// The digest always covers the whole file so ranges can be verified once reassembled
+	var body io.Reader = rfcFile
+	bodyLength := fileInfo.Size()
+	// An empty file has no range to cut, it is answered whole
+	if (request.RangeStart != "" || request.RangeLength != "") && fileInfo.Size() > 0 {
+		start, length, err := parseRange(request.RangeStart, request.RangeLength, fileInfo.Size())
+		if errors.Is(err, errRangeNotSatisfiable) {
+			logger.Debug("Unsatisfiable range", "rfc", rfcNumber, "error", err)
+			return sendRangeNotSatisfiable(conn, logger, request, fileInfo.Size())
+		}
+		if err != nil {
+			logger.Warn("Invalid range", "rfc", rfcNumber, "error", err)
+			return sendErrorResponse(conn, logger, request, StatusBadRequest, "Bad Request")
+		}
+
+		body = io.NewSectionReader(rfcFile, start, length)
+		bodyLength = length
+		responseHeader.Status = StatusPartialContent
+		responseHeader.Phrase = "Partial Content"
+		responseHeader.ContentLength = fmt.Sprintf("%d", length)
+		responseHeader.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, fileInfo.Size())
 	}
 
-	serialized, err := SerializePeerResponse(responseHeader, string(responseData))
+	serialized, err := SerializePeerResponseHeader(responseHeader)
 	if err != nil {
 		return fmt.Errorf("error serializing response: %w", err)
 	}
 
-	serialized = append(serialized, '\n')
-	_, err = conn.Write(serialized)
-	return err
+	uploadsTotal.Inc(strconv.Itoa(responseHeader.Status))
+	if err := conn.WriteFrameHead(common_helpers.PeerResponseIndex, serialized, bodyLength); err != nil {
+		return err
+	}
+
+	sent, err := copyChunks(conn.Conn, body, bodyLength, conn.SetWriteDeadline)
+	uploadBytes.Add(float64(sent))
+	if err != nil {
+		return fmt.Errorf("error sending RFC %s: %w", rfcNumber, err)
+	}
+	logger.Info("Sent RFC", "rfc", rfcNumber, "status", responseHeader.Status, "bytes", sent, "content_range", responseHeader.ContentRange)
+	return nil
 }
 
-func handlePeerRequest(conn net.Conn) error {
-	reader := bufio.NewReader(conn)
+// handlePeerRequest answers the GET request of another peer, logging it under the request ID the peer sent
+func handlePeerRequest(peerConn net.Conn, logger *slog.Logger) error {
+	logger = logger.With("remote", peerConn.RemoteAddr().String())
+
+	// Answer in whichever encoding the requesting peer speaks
+	conn, err := codec.Accept(peerConn)
+	if err != nil {
+		logger.Warn("Error reading peer request", "error", err)
+		return err
+	}
 
-	peerRequest, err := reader.ReadBytes(byte('\n'))
-	
+	frame, err := conn.ReadFrame()
 	if err != nil {
-		log.Printf("Error reading peer request: %v", err)
+		logger.Warn("Error reading peer request", "error", err)
+		return sendErrorResponse(conn, logger, data.PeerRequest{}, 400, "Bad Request")
+	}
+
+	if frame.Type != common_helpers.PeerRequestIndex {
+		logger.Warn("Unexpected message type in peer request", "type", frame.Type)
+		return sendErrorResponse(conn, logger, data.PeerRequest{}, 400, "Bad Request")
 	}
 
-	request, err := DeserializePeerRequest(peerRequest)
+	request, err := DeserializePeerRequest(frame.Header)
 	if err != nil {
-		return sendErrorResponse(conn, 400, "Bad Request")
+		return sendErrorResponse(conn, logger, data.PeerRequest{}, 400, "Bad Request")
+	}
+
+	// Peers that do not trace their requests get an ID of our own
+	if request.RequestID == "" {
+		request.RequestID = common_helpers.NewRequestID()
 	}
+	logger = logger.With("request_id", request.RequestID)
+	logger.Info("GET request", "rfc", request.RFCNumber, "range_start", request.RangeStart, "range_length", request.RangeLength)
 
 	if request.Version != ApplicationVersion {
-		return sendErrorResponse(conn, 505, "P2P-CI Version Not Supported")
+		return sendErrorResponse(conn, logger, request, 505, "P2P-CI Version Not Supported")
 	}
 
 	if request.PeerIP == conn.LocalAddr().String() {
-		return sendErrorResponse(conn, 400, "Bad Request")
+		return sendErrorResponse(conn, logger, request, 400, "Bad Request")
 	}
 
-	return sendSuccessResponse(conn, request.RFCNumber)
+	return sendSuccessResponse(conn, logger, request)
 }
 
 func main() {
-	log.Println("P2P Client starting...")
-
 	// Load configuration
 	loadConfig()
-
-	// Connect to server
-	serverConn, err := connectToServer()
-
-
-	if err != nil {
-		log.Fatalf("Connection failed: %v", err)
-	}
-	defer serverConn.Close()
-
-	log.Println("Successfully connected to server")
-
-	// Load available RFC files
-	if err := loadRFCFiles(); err != nil {
-		log.Fatalf("Failed to load RFC files: %v", err)
-	}
+	peerLog.Info("P2P Client starting...")
 
 	// Get random port for upload server
 	uploadPort, err := getRandomUploadPort()
 	if err != nil {
 		log.Fatalf("Failed to get upload port: %v", err)
 	}
-	log.Printf("Upload server will use port: %s", uploadPort)
+	peerLog.Info("Upload server will use port", "port", uploadPort)
 
 	// Create upload listener
-	uploadListener, err := net.Listen("tcp", ":"+uploadPort)
+	uploadListener, err := listen(uploadPort)
 	if err != nil {
 		log.Fatalf("Failed to create upload listener: %v", err)
 	}
 	defer uploadListener.Close()
 
-	// Register all RFCs with server
-	if err := registerRFCs(serverConn, uploadPort); err != nil {
-		log.Fatalf("Failed to register RFCs: %v", err)
+	// Connect to server, authenticate and register all RFCs, again whenever the connection is lost
+	link, err := dialServerLink(uploadPort)
+	if err != nil {
+		log.Fatalf("Connection failed: %v", err)
 	}
+	defer link.Close()
 
-	log.Println("All RFCs registered successfully")
+	peerLog.Info("Successfully connected to server")
 
 	//This is the IP address of the host machine used to connect to the server
-	hostIP := serverConn.LocalAddr().String()
-	log.Printf("Host IP address: %s", hostIP)
+	hostIP := link.current().LocalAddr().String()
+	peerLog.Info("Host IP address", "address", hostIP)
+
+	// Metrics are only served when asked for, a peer has no HTTP server otherwise
+	if metricsAddr := os.Getenv("METRICS_HTTP_ADDR"); metricsAddr != "" {
+		metricsServer := startMetricsServer(metricsAddr)
+		defer metricsServer.Close()
+		peerLog.Info("Metrics served", "url", metricsAddr+"/metrics")
+	}
+
+	// Files added to the RFCs directory later, downloads included, are announced as they appear
+	if rfcWatchInterval > 0 {
+		go watchRFCs(link, uploadPort, rfcWatchInterval)
+	}
 
 	// Start command loop in goroutine
-	go startCommandLoop(serverConn)
+	go startCommandLoop(link)
 
 	//Set up shutdown signal handling
 	sigChan := make(chan os.Signal, 1)
@@ -335,23 +736,27 @@ func main() {
 			if err != nil {
 				// Check if error is due to listener being closed (expected during shutdown)
 				if strings.Contains(err.Error(), "use of closed network connection") {
-					log.Println("Upload listener closed, shutting down accept loop")
+					peerLog.Info("Upload listener closed, shutting down accept loop")
 					return
 				}
-				log.Printf("Error accepting upload connection: %v", err)
+				peerLog.Error("Error accepting upload connection", "error", err)
 				return
 			}
 
 			go func(c net.Conn) {
 				defer c.Close()
-				handlePeerRequest(c)
+				activeUploads.Inc()
+				defer activeUploads.Dec()
+				started := time.Now()
+				handlePeerRequest(c, link.current().log)
+				uploadDuration.Observe(time.Since(started).Seconds())
 			}(conn)
 		}
 	}()
 
 	// Wait for shutdown signal
 	<-sigChan
-	log.Println("Client Shutting down...")
+	peerLog.Info("Client Shutting down...")
 	uploadListener.Close()
-	serverConn.Close()
+	link.Close()
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/data"
+)
+
+// ErrUnsignedRFC is returned when a downloaded RFC matches no manifest signed by a trusted publisher
+var ErrUnsignedRFC = errors.New("RFC is not signed by a trusted publisher")
+
+// signedManifest is a manifest we signed and the digest of the file it describes
+type signedManifest struct {
+	digest  string
+	encoded string
+}
+
+var (
+	// signedManifests holds the last manifest signed for each RFC we publish, by number and title.
+	// An unchanged file is announced again with the same manifest, so the server sees it is already in the index.
+	signedManifests   = make(map[string]signedManifest)
+	signedManifestsMu sync.Mutex
+)
+
+// signManifest returns the encoded manifest of an RFC file we publish, empty when we have no key to sign with
+// or the file does not have the digest we announce
+func signManifest(rfcNumber, rfcTitle, path, digest string) string {
+	if authKey == nil || digest == "" {
+		return ""
+	}
+
+	key := rfcNumber + "_" + rfcTitle
+	signedManifestsMu.Lock()
+	defer signedManifestsMu.Unlock()
+	if signed, ok := signedManifests[key]; ok && signed.digest == digest {
+		return signed.encoded
+	}
+
+	manifest, err := common_helpers.NewManifest(rfcNumber, rfcTitle, path, authName, authKey)
+	if err != nil || manifest.Digest != digest {
+		return ""
+	}
+	encoded, err := common_helpers.EncodeManifest(manifest)
+	if err != nil {
+		return ""
+	}
+	signedManifests[key] = signedManifest{digest: digest, encoded: encoded}
+	return encoded
+}
+
+// verifySignedRFC checks a downloaded RFC against the manifests the server holds for it.
+// Once trusted publishers are configured, only an RFC matching a manifest one of them signed may be saved,
+// so a peer cannot serve a different document under the number and title of a trusted one.
+func verifySignedRFC(conn *controlConn, cmd *Command, rfcNumber, title, path string) error {
+	if trustedPublishers == nil {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error reading downloaded RFC: %w", err)
+	}
+	digest, err := common_helpers.FileDigest(path)
+	if err != nil {
+		return fmt.Errorf("error computing digest: %w", err)
+	}
+
+	holders, err := lookupHolders(conn, cmd.RequestID, rfcNumber, ApplicationVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, holder := range holders {
+		if holder.RFCManifest == "" {
+			continue
+		}
+		manifest, err := common_helpers.DecodeManifest(holder.RFCManifest)
+		if err != nil {
+			continue
+		}
+		if manifest.RFCNumber != rfcNumber || manifest.RFCTitle != title || manifest.Size != info.Size() || manifest.Digest != digest {
+			continue
+		}
+		if !trustedManifest(manifest) {
+			continue
+		}
+
+		cmd.Log.Info("RFC verified", "rfc", rfcNumber, "publisher", manifest.Publisher, "signed_at", manifest.Timestamp)
+		fmt.Printf("RFC %s verified, signed by %s at %s\n", rfcNumber, manifest.Publisher, manifest.Timestamp)
+		return nil
+	}
+	return fmt.Errorf("%w: RFC %s (%s) with digest %s", ErrUnsignedRFC, rfcNumber, title, digest)
+}
+
+// trustedManifest checks that a manifest is signed by the key of a trusted publisher
+func trustedManifest(manifest data.RFCManifest) bool {
+	key, ok := trustedPublishers[manifest.Publisher]
+	if !ok {
+		return false
+	}
+	return common_helpers.VerifyManifest(manifest, key) == nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"P2P/common-helpers/metrics"
+)
+
+// Results of a GET or FETCH command in the metrics
+const (
+	downloadOK      = "ok"
+	downloadRefused = "refused"
+	downloadFailed  = "failed"
+)
+
+var (
+	// peerMetrics holds every metric of the peer
+	peerMetrics = metrics.NewRegistry()
+
+	uploadsTotal     = peerMetrics.NewCounter("p2p_peer_uploads_total", "GET requests answered by the upload server, by status code.", "code")
+	uploadBytes      = peerMetrics.NewCounter("p2p_peer_upload_bytes_total", "RFC bytes sent to other peers.")
+	uploadDuration   = peerMetrics.NewHistogram("p2p_peer_upload_duration_seconds", "Time spent answering a GET request.", metrics.DurationBuckets)
+	activeUploads    = peerMetrics.NewGauge("p2p_peer_active_uploads", "GET requests being answered.")
+	downloadsTotal   = peerMetrics.NewCounter("p2p_peer_downloads_total", "GET and FETCH commands, by command and result.", "command", "result")
+	downloadBytes    = peerMetrics.NewCounter("p2p_peer_download_bytes_total", "RFC bytes received from other peers.")
+	downloadDuration = peerMetrics.NewHistogram("p2p_peer_download_duration_seconds", "Time spent on a GET or FETCH command, by command.", metrics.DurationBuckets, "command")
+	serverReconnects = peerMetrics.NewCounter("p2p_peer_server_reconnects_total", "Times the connection to the server was lost and opened again.")
+)
+
+// recordDownload counts and logs a finished GET or FETCH command
+func recordDownload(cmd *Command, started time.Time, result string) {
+	elapsed := time.Since(started)
+	downloadsTotal.Inc(string(cmd.Type), result)
+	downloadDuration.Observe(elapsed.Seconds(), string(cmd.Type))
+	cmd.Log.Info("Download finished", "result", result, "duration", elapsed)
+}
+
+// startMetricsServer serves the metrics of the peer on addr in the background
+func startMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", peerMetrics.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: MetricsReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			peerLog.Error("Metrics server error", "error", err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,145 @@
+// This file keeps the on-disk state of interrupted GET downloads so they can be resumed
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// receivedRange is a byte range of an RFC already written to the .part file
+type receivedRange struct {
+	Offset int64 `json:"Offset"`
+	Length int64 `json:"Length"`
+}
+
+// partialState is the sidecar of a .part file, recording what was received and from whom
+type partialState struct {
+	RFCNumber string          `json:"RFC_Number"`
+	Source    string          `json:"Source"`
+	Digest    string          `json:"Digest,omitempty"`
+	Size      int64           `json:"Size"`
+	Received  []receivedRange `json:"Received"`
+
+	// unsaved counts the bytes received since the sidecar was last written
+	unsaved int64
+}
+
+// partFilePath returns the path of the .part file of an RFC.
+// It is hidden so it is never advertised or served while incomplete.
+func partFilePath(rfcNumber string) string {
+	return filepath.Join(RFCsDirectory, "."+rfcNumber+".part")
+}
+
+// partStatePath returns the path of the sidecar of a .part file
+func partStatePath(rfcNumber string) string {
+	return partFilePath(rfcNumber) + ".json"
+}
+
+// loadPartialState loads the state of an interrupted download of an RFC.
+// It returns nil if there is nothing usable to resume.
+func loadPartialState(rfcNumber string) *partialState {
+	content, err := os.ReadFile(partStatePath(rfcNumber))
+	if err != nil {
+		return nil
+	}
+
+	var state partialState
+	if err := json.Unmarshal(content, &state); err != nil {
+		peerLog.Warn("Ignoring corrupt partial state", "rfc", rfcNumber, "error", err)
+		discardPartial(rfcNumber)
+		return nil
+	}
+
+	// The sidecar is only written after the bytes it records, but never trust it past the end of the file
+	fileInfo, err := os.Stat(partFilePath(rfcNumber))
+	if err != nil || state.RFCNumber != rfcNumber || state.resumeOffset() > fileInfo.Size() {
+		peerLog.Warn("Ignoring inconsistent partial state", "rfc", rfcNumber)
+		discardPartial(rfcNumber)
+		return nil
+	}
+	return &state
+}
+
+// save writes the sidecar next to the .part file, replacing the previous one atomically
+func (ps *partialState) save() error {
+	content, err := json.Marshal(ps)
+	if err != nil {
+		return fmt.Errorf("error serializing partial state: %w", err)
+	}
+
+	tmpPath := partStatePath(ps.RFCNumber) + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("error writing partial state: %w", err)
+	}
+	if err := os.Rename(tmpPath, partStatePath(ps.RFCNumber)); err != nil {
+		return fmt.Errorf("error writing partial state: %w", err)
+	}
+
+	ps.unsaved = 0
+	return nil
+}
+
+// markReceived records a received byte range, merging it with the ranges it touches
+func (ps *partialState) markReceived(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+
+	ps.Received = append(ps.Received, receivedRange{Offset: offset, Length: length})
+	slices.SortFunc(ps.Received, func(a, b receivedRange) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	merged := ps.Received[:1]
+	for _, r := range ps.Received[1:] {
+		last := &merged[len(merged)-1]
+		if r.Offset > last.Offset+last.Length {
+			merged = append(merged, r)
+			continue
+		}
+		last.Length = max(last.Length, r.Offset+r.Length-last.Offset)
+	}
+	ps.Received = merged
+	ps.unsaved += length
+}
+
+// resumeOffset returns where the download continues, the end of the bytes received from the start of the file
+func (ps *partialState) resumeOffset() int64 {
+	if len(ps.Received) == 0 || ps.Received[0].Offset != 0 {
+		return 0
+	}
+	return ps.Received[0].Length
+}
+
+// partWriter writes received bytes into the .part file, saving the sidecar every PartialStateInterval bytes
+type partWriter struct {
+	file   *os.File
+	state  *partialState
+	offset int64
+}
+
+func (pw *partWriter) Write(p []byte) (int, error) {
+	n, err := pw.file.WriteAt(p, pw.offset)
+	pw.state.markReceived(pw.offset, int64(n))
+	pw.offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if pw.state.unsaved >= PartialStateInterval {
+		if err := pw.state.save(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// discardPartial removes the .part file of an RFC and its sidecar
+func discardPartial(rfcNumber string) {
+	os.Remove(partFilePath(rfcNumber))
+	os.Remove(partStatePath(rfcNumber))
+}
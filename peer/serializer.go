@@ -14,6 +14,15 @@ func SerializeAddStruct(addStruct data.AddStruct) ([]byte, error) {
 	return jsonData, nil
 }
 
+// SerializeRemoveStruct converts RemoveStruct into a JSON byte array
+func SerializeRemoveStruct(removeStruct data.RemoveStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(removeStruct)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
 // SerializeLookUpStruct converts LookUpStruct into a JSON byte array
 func SerializeLookUpStruct(lookUpStruct data.LookUpStruct) ([]byte, error) {
 	jsonData, err := json.Marshal(lookUpStruct)
@@ -41,10 +50,45 @@ func SerializePeerRequest(peerRequest data.PeerRequest) ([]byte, error) {
 	return jsonData, nil
 }
 
-// SerializePeerResponse converts PeerResponse into a JSON byte array
-func SerializePeerResponse(peerResponse data.PeerResponseHeader, responseData string) ([]byte, error) {
-	jsonData, err := json.Marshal(peerResponse)
-	jsonData = append(jsonData, responseData...)
+// SerializePeerResponseHeader converts PeerResponseHeader into a JSON byte array
+func SerializePeerResponseHeader(peerResponseHeader data.PeerResponseHeader) ([]byte, error) {
+	jsonData, err := json.Marshal(peerResponseHeader)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// SerializeHeartbeatStruct converts HeartbeatStruct into a JSON byte array
+func SerializeHeartbeatStruct(heartbeat data.HeartbeatStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(heartbeat)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// SerializeAuthStruct converts AuthStruct into a JSON byte array
+func SerializeAuthStruct(authStruct data.AuthStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(authStruct)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// SerializeSearchStruct converts SearchStruct into a JSON byte array
+func SerializeSearchStruct(searchStruct data.SearchStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(searchStruct)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// SerializeSubscribeStruct converts SubscribeStruct into a JSON byte array
+func SerializeSubscribeStruct(subscribeStruct data.SubscribeStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(subscribeStruct)
 	if err != nil {
 		return nil, err
 	}
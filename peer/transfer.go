@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// errRangeNotSatisfiable is returned for a well formed range that lies outside the file
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// copyChunks copies exactly length bytes from src to dst in TransferChunkSize chunks.
+// extendDeadline is called before every chunk so only a stalled transfer times out,
+// however long the whole file takes.
+func copyChunks(dst io.Writer, src io.Reader, length int64, extendDeadline func(time.Time) error) (int64, error) {
+	buf := make([]byte, TransferChunkSize)
+	var copied int64
+
+	for copied < length {
+		if err := extendDeadline(time.Now().Add(PeerResponseTimeout)); err != nil {
+			return copied, err
+		}
+
+		chunk := buf
+		if remaining := length - copied; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			if _, werr := dst.Write(chunk[:n]); werr != nil {
+				return copied, werr
+			}
+			copied += int64(n)
+		}
+		if err != nil {
+			return copied, fmt.Errorf("transfer stopped after %d of %d bytes: %w", copied, length, err)
+		}
+	}
+
+	return copied, extendDeadline(time.Time{})
+}
+
+// parseRange validates a requested byte range against the size of a file.
+// A range running past the end of the file is cut short at the end.
+func parseRange(rangeStart, rangeLength string, size int64) (int64, int64, error) {
+	start, err := strconv.ParseInt(rangeStart, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("malformed range start %q", rangeStart)
+	}
+	length, err := strconv.ParseInt(rangeLength, 10, 64)
+	if err != nil || length < 0 {
+		return 0, 0, fmt.Errorf("malformed range length %q", rangeLength)
+	}
+	if length == 0 || start >= size {
+		return 0, 0, fmt.Errorf("%w: %d bytes at %d of a %d byte file", errRangeNotSatisfiable, length, start, size)
+	}
+
+	if length > size-start {
+		length = size - start
+	}
+	return start, length, nil
+}
+
+// parseContentRange parses a "bytes <first>-<last>/<size>" Content-Range header
+func parseContentRange(contentRange string) (int64, int64, int64, error) {
+	var first, last, size int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &first, &last, &size); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range %q", contentRange)
+	}
+	if first < 0 || last < first || last >= size {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return first, last, size, nil
+}
@@ -0,0 +1,205 @@
+// This file watches the RFCs directory so the index always reflects what we can actually serve
+package main
+
+import (
+	"os"
+	"time"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/data"
+)
+
+// rfcWatcher polls the RFCs directory: new files are added to the index, deleted ones removed and changed ones announced again.
+// A file is only acted on once it has not changed for RFCWatchSettle, so copies and edits in progress are left alone.
+type rfcWatcher struct {
+	link       *serverLink
+	uploadPort string
+
+	// seen is the state of every file at the last poll, files that disappeared are kept until their removal is handled
+	seen map[string]watchedFile
+}
+
+// watchedFile is the state of a file of the RFCs directory and since when it has been in it
+type watchedFile struct {
+	present bool
+	size    int64
+	modTime time.Time
+	since   time.Time
+
+	// handled is set once the watcher acted on this state, it acts again when the file changes
+	handled bool
+}
+
+// watchRFCs polls the RFCs directory every interval until the peer shuts down
+func watchRFCs(link *serverLink, uploadPort string, interval time.Duration) {
+	w := &rfcWatcher{
+		link:       link,
+		uploadPort: uploadPort,
+		seen:       make(map[string]watchedFile),
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.poll(time.Now())
+		case <-link.stopping:
+			return
+		}
+	}
+}
+
+// poll compares the RFCs directory with what the server was told and announces the files that settled since
+func (w *rfcWatcher) poll(now time.Time) {
+	conn := w.link.current()
+	// While we reconnect there is nobody to tell, the new session registers the directory as it is then
+	select {
+	case <-conn.closed:
+		return
+	default:
+	}
+
+	entries, err := os.ReadDir(RFCsDirectory)
+	if err != nil {
+		conn.log.Warn("Error reading RFC directory", "dir", RFCsDirectory, "error", err)
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		if !isServedFile(entry) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		present[entry.Name()] = true
+		w.observe(entry.Name(), watchedFile{present: true, size: info.Size(), modTime: info.ModTime()}, now)
+	}
+	for filename := range w.seen {
+		if !present[filename] {
+			w.observe(filename, watchedFile{}, now)
+		}
+	}
+	for filename := range conn.published {
+		if !present[filename] {
+			w.observe(filename, watchedFile{}, now)
+		}
+	}
+
+	for filename, file := range w.seen {
+		if file.handled || now.Sub(file.since) < RFCWatchSettle {
+			continue
+		}
+		file.handled = true
+		w.seen[filename] = file
+
+		published, announced := conn.published[filename]
+		switch {
+		case !file.present:
+			if announced {
+				w.remove(conn, filename, published)
+			}
+			delete(w.seen, filename)
+		case !announced || published.Size != file.size || !published.ModTime.Equal(file.modTime):
+			w.add(conn, filename)
+		}
+	}
+}
+
+// observe records the state of a file, restarting its settling time if it changed
+func (w *rfcWatcher) observe(filename string, file watchedFile, now time.Time) {
+	previous, ok := w.seen[filename]
+	if ok && previous.present == file.present && previous.size == file.size && previous.modTime.Equal(file.modTime) {
+		return
+	}
+	file.since = now
+	w.seen[filename] = file
+}
+
+// add announces a new or changed file to the server, the index keeps one entry per number and title so a changed file replaces its entry
+func (w *rfcWatcher) add(conn *controlConn, filename string) {
+	rfc, err := readLocalRFC(filename)
+	if err != nil {
+		conn.log.Warn("Not announcing RFC file", "file", filename, "error", err)
+		return
+	}
+
+	// A file that was only touched needs no announcement
+	published, announced := conn.published[filename]
+	if announced && published.Digest == rfc.Digest {
+		conn.published[filename] = rfc
+		return
+	}
+
+	addStruct := rfc.addStruct(conn, w.uploadPort)
+	logger := conn.log.With("request_id", addStruct.RequestID, "rfc", rfc.Number, "title", rfc.Title)
+	serialized, err := SerializeAddStruct(addStruct)
+	if err != nil {
+		logger.Error("Error serializing AddStruct", "error", err)
+		return
+	}
+
+	response, err := roundTrip(conn, common_helpers.AddStructIndex, addStruct.RequestID, serialized)
+	if err != nil {
+		logger.Warn("Error announcing RFC file", "error", err)
+		return
+	}
+	if response.Header.ResponseCode != StatusOK {
+		logger.Warn("Server refused RFC file", "code", response.Header.ResponseCode, "phrase", response.Header.ResponsePhrase)
+		return
+	}
+
+	conn.published[filename] = rfc
+	if announced {
+		logger.Info("Announced changed RFC file", "digest", rfc.Digest)
+	} else {
+		logger.Info("Announced new RFC file", "digest", rfc.Digest)
+	}
+}
+
+// remove withdraws a deleted file from the index
+func (w *rfcWatcher) remove(conn *controlConn, filename string, rfc localRFC) {
+	// Whatever the answer, the file is gone and the server is not told about it again
+	delete(conn.published, filename)
+
+	removeStruct := data.RemoveStruct{
+		RFCNumber:                rfc.Number,
+		RFCTitle:                 rfc.Title,
+		ClientIP:                 conn.LocalAddr().String(),
+		ClientUploadPort:         w.uploadPort,
+		ClientApplicationVersion: ApplicationVersion,
+		RequestID:                common_helpers.NewRequestID(),
+	}
+	logger := conn.log.With("request_id", removeStruct.RequestID, "rfc", rfc.Number, "title", rfc.Title)
+	serialized, err := SerializeRemoveStruct(removeStruct)
+	if err != nil {
+		logger.Error("Error serializing RemoveStruct", "error", err)
+		return
+	}
+
+	response, err := roundTrip(conn, common_helpers.RemoveStructIndex, removeStruct.RequestID, serialized)
+	if err != nil {
+		logger.Warn("Error withdrawing RFC file", "error", err)
+		return
+	}
+	if response.Header.ResponseCode != StatusOK && response.Header.ResponseCode != StatusNotFound {
+		logger.Warn("Server refused to withdraw RFC file", "code", response.Header.ResponseCode, "phrase", response.Header.ResponsePhrase)
+		return
+	}
+	logger.Info("Withdrew deleted RFC file")
+}
+
+// roundTrip sends a request to the server and waits for its response
+func roundTrip(conn *controlConn, frameType byte, requestID string, serialized []byte) (data.ServerResponse, error) {
+	request, err := conn.send(frameType, requestID, serialized)
+	if err != nil {
+		return data.ServerResponse{}, err
+	}
+	defer request.close()
+
+	return readServerResponse(request)
+}
@@ -0,0 +1,160 @@
+// This file serves the HTTP/JSON admin API and the metrics, for dashboards and scripts that do not speak P2P-CI
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// adminPeer is a peer as reported by /peers
+type adminPeer struct {
+	PeerID       string `json:"Peer_ID"`
+	Address      string `json:"Address"`
+	UploadPort   string `json:"Upload_Port"`
+	RFCs         int    `json:"RFCs"`
+	LeaseExpires string `json:"Lease_Expires,omitempty"`
+}
+
+// adminRFC is an index entry as reported by /rfcs
+type adminRFC struct {
+	RFCNumber   string `json:"RFC_Number"`
+	RFCTitle    string `json:"RFC_Title"`
+	RFCDigest   string `json:"RFC_Digest,omitempty"`
+	RFCManifest string `json:"RFC_Manifest,omitempty"`
+	PeerID      string `json:"Peer_ID"`
+	Address     string `json:"Address"`
+	UploadPort  string `json:"Upload_Port"`
+}
+
+// adminHealth is the status reported by /health
+type adminHealth struct {
+	Status  string `json:"Status"`
+	Version string `json:"Version"`
+	Uptime  string `json:"Uptime"`
+	Peers   int    `json:"Peers"`
+	RFCs    int    `json:"RFCs"`
+}
+
+// adminError is the body of every failed admin request
+type adminError struct {
+	Error string `json:"Error"`
+}
+
+// newAdminHandler routes the admin API, it only ever reads the index
+func newAdminHandler(started time.Time) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, adminHealth{
+			Status:  "ok",
+			Version: ApplicationVersion,
+			Uptime:  time.Since(started).Round(time.Second).String(),
+			Peers:   indexStore.PeerCount(),
+			RFCs:    indexStore.EntryCount(),
+		})
+	})
+	mux.HandleFunc("GET /peers", handleAdminPeers)
+	mux.HandleFunc("GET /rfcs", handleAdminRFCs)
+	mux.HandleFunc("GET /rfcs/{number}", handleAdminRFC)
+	mux.Handle("GET /metrics", serverMetrics.Handler())
+	return mux
+}
+
+// handleAdminPeers lists every peer with the number of RFCs it advertises
+func handleAdminPeers(w http.ResponseWriter, r *http.Request) {
+	peers := []adminPeer{}
+	for _, peer := range indexStore.Peers() {
+		reported := adminPeer{
+			PeerID:     peer.PeerID,
+			Address:    peer.Hostname,
+			UploadPort: peer.UploadPort,
+			RFCs:       peer.RFCs,
+		}
+		if expires, ok := leases.expiry(peer.PeerID); ok {
+			reported.LeaseExpires = expires.UTC().Format(time.RFC3339)
+		}
+		peers = append(peers, reported)
+	}
+	slices.SortFunc(peers, func(a, b adminPeer) int {
+		return cmp.Compare(a.PeerID, b.PeerID)
+	})
+	writeJSON(w, http.StatusOK, peers)
+}
+
+// handleAdminRFCs lists the whole index
+func handleAdminRFCs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, adminRFCs(""))
+}
+
+// handleAdminRFC lists the peers holding one RFC
+func handleAdminRFC(w http.ResponseWriter, r *http.Request) {
+	number := r.PathValue("number")
+	if _, err := strconv.ParseUint(number, 10, 64); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "RFC number must be numeric"})
+		return
+	}
+
+	rfcs := adminRFCs(number)
+	if len(rfcs) == 0 {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "RFC " + number + " is not in the index"})
+		return
+	}
+	writeJSON(w, http.StatusOK, rfcs)
+}
+
+// adminRFCs returns the index entries of an RFC number, or every entry for an empty number, ordered by RFC number
+func adminRFCs(number string) []adminRFC {
+	var entries []IndexEntry
+	if number == "" {
+		entries = indexStore.Entries()
+	} else {
+		entries = indexStore.Lookup(number, "")
+	}
+
+	rfcs := []adminRFC{}
+	for _, entry := range entries {
+		rfcs = append(rfcs, adminRFC{
+			RFCNumber:   entry.RFCNumber,
+			RFCTitle:    entry.RFCTitle,
+			RFCDigest:   entry.RFCDigest,
+			RFCManifest: entry.RFCManifest,
+			PeerID:      entry.PeerID,
+			Address:     entry.Hostname,
+			UploadPort:  entry.UploadPort,
+		})
+	}
+
+	slices.SortFunc(rfcs, func(a, b adminRFC) int {
+		an, _ := strconv.Atoi(a.RFCNumber)
+		bn, _ := strconv.Atoi(b.RFCNumber)
+		return cmp.Or(cmp.Compare(an, bn), cmp.Compare(a.RFCTitle, b.RFCTitle), cmp.Compare(a.PeerID, b.PeerID))
+	})
+	return rfcs
+}
+
+// writeJSON sends a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		serverLog.Warn("Error writing admin response", "error", err)
+	}
+}
+
+// startAdminServer serves the admin API on addr in the background
+func startAdminServer(addr string, started time.Time) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newAdminHandler(started),
+		ReadHeaderTimeout: AdminReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverLog.Error("Admin API error", "error", err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,126 @@
+// This file authenticates peers against the credentials registered with the server
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/data"
+)
+
+// authCredential is a peer registered in the keys file
+type authCredential struct {
+	Name   string
+	Method string
+
+	// Token is the shared secret of the token method, PublicKey the key of the ed25519 method
+	Token     string
+	PublicKey ed25519.PublicKey
+
+	// CanPublish allows the peer to ADD and REMOVE, read-only credentials can only LOOKUP and LIST
+	CanPublish bool
+}
+
+// authRegistry holds the registered credentials by name
+type authRegistry struct {
+	credentials map[string]authCredential
+
+	// anonymousRead lets sessions that did not authenticate LOOKUP and LIST
+	anonymousRead bool
+}
+
+// loadAuthRegistry reads the credentials of the keys file, see common_helpers.ReadKeysFile.
+// The role of a credential is publish or read and defaults to publish.
+func loadAuthRegistry(path string, anonymousRead bool) (*authRegistry, error) {
+	entries, err := common_helpers.ReadKeysFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &authRegistry{credentials: make(map[string]authCredential), anonymousRead: anonymousRead}
+	for _, entry := range entries {
+		credential, err := parseAuthCredential(entry)
+		if err != nil {
+			return nil, fmt.Errorf("error in keys file line %d: %w", entry.Line, err)
+		}
+		if _, ok := registry.credentials[credential.Name]; ok {
+			return nil, fmt.Errorf("error in keys file line %d: %s is registered twice", entry.Line, credential.Name)
+		}
+		registry.credentials[credential.Name] = credential
+	}
+	return registry, nil
+}
+
+// parseAuthCredential checks a keys file entry and decodes its credential
+func parseAuthCredential(entry common_helpers.KeysFileEntry) (authCredential, error) {
+	credential := authCredential{Name: entry.Name, Method: entry.Method, CanPublish: true}
+	switch credential.Method {
+	case common_helpers.AuthMethodToken:
+		credential.Token = entry.Credential
+	case common_helpers.AuthMethodEd25519:
+		key, err := common_helpers.DecodePublicKey(entry.Credential)
+		if err != nil {
+			return authCredential{}, err
+		}
+		credential.PublicKey = key
+	default:
+		return authCredential{}, fmt.Errorf("unknown method %q", credential.Method)
+	}
+
+	switch entry.Role {
+	case "", AuthRolePublish:
+	case AuthRoleRead:
+		credential.CanPublish = false
+	default:
+		return authCredential{}, fmt.Errorf("unknown role %q", entry.Role)
+	}
+	return credential, nil
+}
+
+// authenticate checks the credential a session presents, the challenge of ed25519 keys is bound to the session
+func (r *authRegistry) authenticate(session *peerSession, auth data.AuthStruct) (authCredential, bool) {
+	credential, ok := r.credentials[auth.Name]
+	if !ok || credential.Method != auth.Method {
+		return authCredential{}, false
+	}
+
+	switch credential.Method {
+	case common_helpers.AuthMethodToken:
+		ok = subtle.ConstantTimeCompare([]byte(credential.Token), []byte(auth.Credential)) == 1
+	case common_helpers.AuthMethodEd25519:
+		signature, err := base64.StdEncoding.DecodeString(auth.Credential)
+		ok = err == nil && ed25519.Verify(credential.PublicKey, common_helpers.AuthChallenge(session.ID), signature)
+	default:
+		ok = false
+	}
+	return credential, ok
+}
+
+// checkManifest validates the manifest of an ADD request, returning the status to refuse the request with otherwise.
+// The manifest must describe the RFC being added. When publishers authenticate it must also be signed
+// with the key of the session, without a keys file it is stored as it is and only the downloading peers verify it.
+func checkManifest(session *peerSession, addStruct data.AddStruct) (int, string, bool) {
+	if addStruct.RFCManifest == "" {
+		return 0, "", true
+	}
+
+	manifest, err := common_helpers.DecodeManifest(addStruct.RFCManifest)
+	if err != nil || manifest.RFCNumber != addStruct.RFCNumber || manifest.RFCTitle != addStruct.RFCTitle || manifest.Digest != addStruct.RFCDigest {
+		return StatusBadRequest, "Bad Request", false
+	}
+	if authKeys == nil {
+		return 0, "", true
+	}
+
+	credential, ok := authKeys.credentials[manifest.Publisher]
+	if !ok || manifest.Publisher != session.Identity || credential.Method != common_helpers.AuthMethodEd25519 {
+		return StatusForbidden, "Forbidden", false
+	}
+	if err := common_helpers.VerifyManifest(manifest, credential.PublicKey); err != nil {
+		return StatusForbidden, "Forbidden", false
+	}
+	return 0, "", true
+}
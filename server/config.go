@@ -1,6 +1,8 @@
 // This file stores the application version and server configuration constants
 package main
 
+import "time"
+
 const (
 	// ApplicationVersion is the P2P protocol version
 	ApplicationVersion = "P2P-CI/1.0"
@@ -8,9 +10,65 @@ const (
 	// DefaultServerPort is the default port for accepting client connections
 	DefaultServerPort = "7734"
 
+	// Index store backends selectable through INDEX_STORE
+	IndexStoreFile   = "file"
+	IndexStoreMemory = "memory"
+
+	// DefaultIndexStoreDir is the default directory of the on-disk index store
+	DefaultIndexStoreDir = "./index"
+
+	// StoreCompactionThreshold is the number of log records after which the index is snapshotted
+	StoreCompactionThreshold = 1000
+
+	// MaxRFCTitleLength bounds the title of an ADD, it is written to the index log and repeated in every response listing the RFC
+	MaxRFCTitleLength = 1 << 10
+
+	// StoreReconcileDialTimeout is the timeout for probing the upload port of a restored peer
+	StoreReconcileDialTimeout = 2 * time.Second
+
+	// HandshakeTimeout is how long a new connection may take to announce its connection mode.
+	// Peers that stay silent predate single-port mode and get a dedicated port.
+	HandshakeTimeout = 2 * time.Second
+
+	// PeerIDLength is the number of random bytes in the peer ID of a session
+	PeerIDLength = 16
+
+	// DefaultPeerLeaseDuration is how long a peer stays in the index without being heard from,
+	// it should cover a few of the heartbeats peers send
+	DefaultPeerLeaseDuration = 90 * time.Second
+
+	// LeaseSweepsPerDuration is how many times expired leases are looked for during one lease duration
+	LeaseSweepsPerDuration = 3
+
+	// DefaultAdminAddress is where the HTTP admin API listens unless ADMIN_HTTP_ADDR says otherwise.
+	// It only accepts local connections by default, the API is not authenticated.
+	DefaultAdminAddress = "localhost:8734"
+
+	// AdminDisabled as ADMIN_HTTP_ADDR turns the admin API off
+	AdminDisabled = "off"
+
+	// AdminReadHeaderTimeout bounds how long an admin request may take to send its headers
+	AdminReadHeaderTimeout = 5 * time.Second
+
+	// DefaultListPageSize is the number of RFCs in a page of a LIST response that does not ask for a size
+	DefaultListPageSize = 100
+
+	// MaxListPageSize bounds the size of a page, and so the size of a single LIST response
+	MaxListPageSize = 1000
+
+	// SubscriberQueueLength is how many notifications may wait for a slow subscriber before new ones are dropped
+	SubscriberQueueLength = 256
+
+	// Roles of the credentials in the keys file, read-only credentials cannot change the index
+	AuthRolePublish = "publish"
+	AuthRoleRead    = "read"
+
 	// HTTP status code equivalents for P2P protocol
 	StatusOK                  = 200
 	StatusBadRequest          = 400
+	StatusUnauthorized        = 401
+	StatusForbidden           = 403
 	StatusNotFound            = 404
+	StatusInternalServerError = 500
 	StatusVersionNotSupported = 505
 )
@@ -36,3 +36,53 @@ func DeserializeListStruct(b []byte) (data.ListStruct, error) {
 	}
 	return listStruct, nil
 }
+
+// DeserializeRemoveStruct converts a JSON byte array into a RemoveStruct
+func DeserializeRemoveStruct(b []byte) (data.RemoveStruct, error) {
+	var removeStruct data.RemoveStruct
+	err := json.Unmarshal(b, &removeStruct)
+	if err != nil {
+		return removeStruct, err
+	}
+	return removeStruct, nil
+}
+
+// DeserializeHeartbeatStruct converts a JSON byte array into a HeartbeatStruct
+func DeserializeHeartbeatStruct(b []byte) (data.HeartbeatStruct, error) {
+	var heartbeat data.HeartbeatStruct
+	err := json.Unmarshal(b, &heartbeat)
+	if err != nil {
+		return heartbeat, err
+	}
+	return heartbeat, nil
+}
+
+// DeserializeSearchStruct converts a JSON byte array into a SearchStruct
+func DeserializeSearchStruct(b []byte) (data.SearchStruct, error) {
+	var searchStruct data.SearchStruct
+	err := json.Unmarshal(b, &searchStruct)
+	if err != nil {
+		return searchStruct, err
+	}
+	return searchStruct, nil
+}
+
+// DeserializeAuthStruct converts a JSON byte array into an AuthStruct
+func DeserializeAuthStruct(b []byte) (data.AuthStruct, error) {
+	var authStruct data.AuthStruct
+	err := json.Unmarshal(b, &authStruct)
+	if err != nil {
+		return authStruct, err
+	}
+	return authStruct, nil
+}
+
+// DeserializeSubscribeStruct converts a JSON byte array into a SubscribeStruct
+func DeserializeSubscribeStruct(b []byte) (data.SubscribeStruct, error) {
+	var subscribeStruct data.SubscribeStruct
+	err := json.Unmarshal(b, &subscribeStruct)
+	if err != nil {
+		return subscribeStruct, err
+	}
+	return subscribeStruct, nil
+}
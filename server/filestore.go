@@ -0,0 +1,349 @@
+// This file implements the on-disk index store (append-only log plus snapshot)
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// Operations recorded in the append-only log
+	storeOpAddRFC     = "ADD_RFC"
+	storeOpAddPeer    = "ADD_PEER"
+	storeOpRemovePeer = "REMOVE_PEER"
+	storeOpRemoveRFCs = "REMOVE_RFCS"
+	storeOpRemoveRFC  = "REMOVE_RFC"
+
+	snapshotFileName = "index.snapshot"
+	logFileName      = "index.log"
+)
+
+// storeRecord is a single mutation written to the append-only log.
+// PeerID keeps its old JSON name, logs written before peer IDs existed hold the peer address there.
+type storeRecord struct {
+	Op          string `json:"Op"`
+	PeerID      string `json:"Hostname"`
+	Address     string `json:"Address,omitempty"`
+	UploadPort  string `json:"Upload_Port,omitempty"`
+	RFCNumber   string `json:"RFC_Number,omitempty"`
+	RFCTitle    string `json:"RFC_Title,omitempty"`
+	RFCDigest   string `json:"RFC_Digest,omitempty"`
+	RFCManifest string `json:"RFC_Manifest,omitempty"`
+}
+
+// storeSnapshot is the compacted state of the whole index.
+// PeerInfo maps peer IDs to upload ports and PeerHost to the address the peer connected from,
+// RFCIndex holds the [RFC_Number, RFC_Title, RFC_Digest, RFC_Manifest] quadruples of every peer.
+type storeSnapshot struct {
+	PeerInfo map[string]string     `json:"Peer_Info"`
+	PeerHost map[string]string     `json:"Peer_Host,omitempty"`
+	RFCIndex map[string][][]string `json:"RFC_Index"`
+}
+
+// fileStore keeps the index in memory and persists every mutation to disk.
+// On boot the snapshot is loaded and the log replayed on top of it.
+type fileStore struct {
+	*memoryStore
+
+	dir string
+
+	// logMu serialises writes to the log and compaction
+	logMu      sync.Mutex
+	logFile    *os.File
+	logRecords int
+
+	// restored holds the peer IDs loaded from disk that have not reconnected yet
+	restoredMu sync.Mutex
+	restored   map[string]bool
+}
+
+// openFileStore loads the index from dir, creating the directory if needed
+func openFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating index store directory: %w", err)
+	}
+
+	fs := &fileStore{
+		memoryStore: newMemoryStore(),
+		dir:         dir,
+		restored:    make(map[string]bool),
+	}
+
+	if err := fs.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := fs.replayLog(); err != nil {
+		return nil, err
+	}
+
+	for _, peerID := range fs.PeerIDs() {
+		fs.restored[peerID] = true
+	}
+	serverLog.Info("Restored index", "peers", len(fs.restored), "dir", dir)
+
+	// Start from a fresh log so replay on the next boot stays short
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// loadSnapshot reads the last compacted snapshot if there is one
+func (fs *fileStore) loadSnapshot() error {
+	b, err := os.ReadFile(filepath.Join(fs.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading index snapshot: %w", err)
+	}
+
+	var snapshot storeSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return fmt.Errorf("error decoding index snapshot: %w", err)
+	}
+
+	fs.restore(snapshot)
+	return nil
+}
+
+// replayLog applies every record written after the last snapshot
+func (fs *fileStore) replayLog() error {
+	f, err := os.Open(filepath.Join(fs.dir, logFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening index log: %w", err)
+	}
+	defer f.Close()
+
+	// Records are read whole whatever their size, a line limit would make a large ADD unreadable after a crash
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var record storeRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				// A torn write at the tail of the log is expected after a crash
+				serverLog.Warn("Skipping corrupt index log record", "error", err)
+			} else {
+				fs.apply(record)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading index log: %w", err)
+		}
+	}
+}
+
+// apply performs a logged mutation on the in-memory index
+func (fs *fileStore) apply(record storeRecord) {
+	switch record.Op {
+	case storeOpAddRFC:
+		fs.memoryStore.AddRFC(record.PeerID, record.RFCNumber, record.RFCTitle, record.RFCDigest, record.RFCManifest)
+	case storeOpAddPeer:
+		fs.memoryStore.AddPeer(record.PeerID, record.Address, record.UploadPort)
+	case storeOpRemovePeer:
+		fs.memoryStore.RemovePeer(record.PeerID)
+	case storeOpRemoveRFCs:
+		fs.memoryStore.RemoveRFCs(record.PeerID)
+	case storeOpRemoveRFC:
+		fs.memoryStore.RemoveRFC(record.PeerID, record.RFCNumber, record.RFCTitle)
+	default:
+		serverLog.Warn("Unknown index log operation", "op", record.Op)
+	}
+}
+
+// appendLog writes a record to the log and applies it to the in-memory index
+func (fs *fileStore) appendLog(record storeRecord) error {
+	fs.logMu.Lock()
+	defer fs.logMu.Unlock()
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error serializing index log record: %w", err)
+	}
+	b = append(b, '\n')
+	if _, err := fs.logFile.Write(b); err != nil {
+		return fmt.Errorf("error writing index log: %w", err)
+	}
+
+	fs.apply(record)
+	fs.logRecords++
+
+	if fs.logRecords >= StoreCompactionThreshold {
+		return fs.compactLocked()
+	}
+	return nil
+}
+
+// compact writes a snapshot of the index and truncates the log
+func (fs *fileStore) compact() error {
+	fs.logMu.Lock()
+	defer fs.logMu.Unlock()
+	return fs.compactLocked()
+}
+
+func (fs *fileStore) compactLocked() error {
+	b, err := json.Marshal(fs.snapshot())
+	if err != nil {
+		return fmt.Errorf("error serializing index snapshot: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a half written snapshot
+	snapshotPath := filepath.Join(fs.dir, snapshotFileName)
+	tmpPath := snapshotPath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return fmt.Errorf("error writing index snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, snapshotPath); err != nil {
+		return fmt.Errorf("error replacing index snapshot: %w", err)
+	}
+
+	if fs.logFile != nil {
+		fs.logFile.Close()
+	}
+	logFile, err := os.OpenFile(filepath.Join(fs.dir, logFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error truncating index log: %w", err)
+	}
+	fs.logFile = logFile
+	fs.logRecords = 0
+
+	serverLog.Info("Compacted index store", "dir", fs.dir)
+	return nil
+}
+
+func (fs *fileStore) AddRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) error {
+	return fs.appendLog(storeRecord{Op: storeOpAddRFC, PeerID: peerID, RFCNumber: rfcNumber, RFCTitle: rfcTitle, RFCDigest: rfcDigest, RFCManifest: rfcManifest})
+}
+
+func (fs *fileStore) AddPeer(peerID, hostname, uploadPort string) error {
+	if err := fs.appendLog(storeRecord{Op: storeOpAddPeer, PeerID: peerID, Address: hostname, UploadPort: uploadPort}); err != nil {
+		return err
+	}
+	fs.forgetRestored(peerID)
+	// Dialing restored peers can take a while, the ADD is not held up by it
+	go fs.reconcile(peerID, hostname, uploadPort)
+	return nil
+}
+
+func (fs *fileStore) RemovePeer(peerID string) error {
+	fs.forgetRestored(peerID)
+	return fs.appendLog(storeRecord{Op: storeOpRemovePeer, PeerID: peerID})
+}
+
+func (fs *fileStore) RemoveRFC(peerID, rfcNumber, rfcTitle string) ([]string, error) {
+	// Nothing is logged for an RFC that is not there
+	titles := fs.memoryStore.matchingTitles(peerID, rfcNumber, rfcTitle)
+	if len(titles) == 0 {
+		return titles, nil
+	}
+	if err := fs.appendLog(storeRecord{Op: storeOpRemoveRFC, PeerID: peerID, RFCNumber: rfcNumber, RFCTitle: rfcTitle}); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+func (fs *fileStore) RemoveRFCs(peerID string) error {
+	fs.forgetRestored(peerID)
+	return fs.appendLog(storeRecord{Op: storeOpRemoveRFCs, PeerID: peerID})
+}
+
+func (fs *fileStore) Close() error {
+	if err := fs.compact(); err != nil {
+		return err
+	}
+
+	fs.logMu.Lock()
+	defer fs.logMu.Unlock()
+	return fs.logFile.Close()
+}
+
+// forgetRestored stops tracking a restored peer
+func (fs *fileStore) forgetRestored(peerID string) {
+	fs.restoredMu.Lock()
+	defer fs.restoredMu.Unlock()
+	delete(fs.restored, peerID)
+}
+
+// claimRestored stops tracking a restored peer and reports whether it was still tracked,
+// so a peer made stale by several reconnects is evicted once
+func (fs *fileStore) claimRestored(peerID string) bool {
+	fs.restoredMu.Lock()
+	defer fs.restoredMu.Unlock()
+	_, ok := fs.restored[peerID]
+	delete(fs.restored, peerID)
+	return ok
+}
+
+// reconcile drops restored entries made stale by a peer reconnecting from the same IP.
+// A restored entry is stale if it advertises the same upload port (the peer re-registered
+// under a new connection) or if its upload port no longer accepts connections.
+// Stale entries are removed like evicted ones, so subscribers are told the RFCs are gone.
+func (fs *fileStore) reconcile(peerID, hostname, uploadPort string) {
+	peerIP := hostIP(hostname)
+
+	fs.restoredMu.Lock()
+	restoredPeers := []string{}
+	for restoredPeer := range fs.restored {
+		if restoredPeer != peerID {
+			restoredPeers = append(restoredPeers, restoredPeer)
+		}
+	}
+	fs.restoredMu.Unlock()
+
+	for _, restoredPeer := range restoredPeers {
+		restored, _ := fs.peer(restoredPeer)
+		restoredIP := hostIP(restored.Hostname)
+		restoredPort := restored.UploadPort
+
+		if restoredIP != peerIP {
+			continue
+		}
+		if restoredPort != uploadPort && uploadPortReachable(peerIP, restoredPort) {
+			continue
+		}
+
+		// The lease sweeper or another reconcile may have removed it while we were dialing
+		if !fs.claimRestored(restoredPeer) {
+			continue
+		}
+		logger := serverLog.With("peer_id", restoredPeer)
+		logger.Info("Reconciling stale index entries", "reconnected_peer_id", peerID, "hostname", hostname)
+		removeRFCIndex(logger, restoredPeer)
+		removePeerInfo(logger, restoredPeer)
+	}
+}
+
+// hostIP strips the port from a host:port key
+func hostIP(hostname string) string {
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return hostname
+	}
+	return host
+}
+
+// uploadPortReachable checks if a peer still accepts connections on its upload port
+func uploadPortReachable(ip, uploadPort string) bool {
+	if uploadPort == "" {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, uploadPort), StoreReconcileDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// openTestStore opens the file store kept in dir, failing the test if it cannot be read back
+func openTestStore(t *testing.T, dir string) *fileStore {
+	t.Helper()
+
+	fs, err := openFileStore(dir)
+	if err != nil {
+		t.Fatalf("openFileStore: %v", err)
+	}
+	return fs
+}
+
+// crash drops a store without compacting it, as if the server died, so the next open replays the log
+func crash(t *testing.T, fs *fileStore) {
+	t.Helper()
+
+	if err := fs.logFile.Close(); err != nil {
+		t.Fatalf("closing index log: %v", err)
+	}
+}
+
+// sortedEntries returns the entries of the store in a stable order
+func sortedEntries(fs *fileStore) []IndexEntry {
+	entries := fs.Entries()
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return cmp.Or(cmp.Compare(a.PeerID, b.PeerID), cmp.Compare(a.RFCNumber, b.RFCNumber), cmp.Compare(a.RFCTitle, b.RFCTitle))
+	})
+	return entries
+}
+
+// sortedPeers returns the peers of the store in a stable order
+func sortedPeers(fs *fileStore) []PeerEntry {
+	peers := fs.Peers()
+	slices.SortFunc(peers, func(a, b PeerEntry) int { return cmp.Compare(a.PeerID, b.PeerID) })
+	return peers
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	longTitle := strings.Repeat("Long title ", 7<<10)
+
+	tests := []struct {
+		name    string
+		ops     func(fs *fileStore) error
+		entries []IndexEntry
+		peers   []PeerEntry
+	}{
+		{
+			name:    "empty",
+			ops:     func(fs *fileStore) error { return nil },
+			entries: []IndexEntry{},
+			peers:   []PeerEntry{},
+		},
+		{
+			name: "peers and RFCs",
+			ops: func(fs *fileStore) error {
+				return errors.Join(
+					fs.AddRFC("p1", "7", "Seven", "digest-7", ""),
+					fs.AddPeer("p1", "10.0.0.1:40000", "5000"),
+					fs.AddRFC("p1", "8", "Eight", "", `{"Signature":"c2ln"}`),
+					fs.AddRFC("p2", "7", "Seven", "digest-7", ""),
+					fs.AddPeer("p2", "10.0.0.2:40000", "5001"),
+				)
+			},
+			entries: []IndexEntry{
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCNumber: "7", RFCTitle: "Seven", RFCDigest: "digest-7"},
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCNumber: "8", RFCTitle: "Eight", RFCManifest: `{"Signature":"c2ln"}`},
+				{PeerID: "p2", Hostname: "10.0.0.2:40000", UploadPort: "5001", RFCNumber: "7", RFCTitle: "Seven", RFCDigest: "digest-7"},
+			},
+			peers: []PeerEntry{
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCs: 2},
+				{PeerID: "p2", Hostname: "10.0.0.2:40000", UploadPort: "5001", RFCs: 1},
+			},
+		},
+		{
+			name: "changed digest replaces the entry",
+			ops: func(fs *fileStore) error {
+				return errors.Join(
+					fs.AddPeer("p1", "10.0.0.1:40000", "5000"),
+					fs.AddRFC("p1", "7", "Seven", "old", ""),
+					fs.AddRFC("p1", "7", "Seven", "new", ""),
+				)
+			},
+			entries: []IndexEntry{
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCNumber: "7", RFCTitle: "Seven", RFCDigest: "new"},
+			},
+			peers: []PeerEntry{{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCs: 1}},
+		},
+		{
+			name: "removals",
+			ops: func(fs *fileStore) error {
+				err := errors.Join(
+					fs.AddPeer("p1", "10.0.0.1:40000", "5000"),
+					fs.AddRFC("p1", "7", "Seven", "", ""),
+					fs.AddRFC("p1", "7", "Other seven", "", ""),
+					fs.AddRFC("p1", "8", "Eight", "", ""),
+					fs.AddPeer("p2", "10.0.0.2:40000", "5001"),
+					fs.AddRFC("p2", "9", "Nine", "", ""),
+				)
+				if err != nil {
+					return err
+				}
+				// An empty title withdraws every title of the number
+				if _, err := fs.RemoveRFC("p1", "7", ""); err != nil {
+					return err
+				}
+				return errors.Join(fs.RemoveRFCs("p2"), fs.RemovePeer("p2"))
+			},
+			entries: []IndexEntry{
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCNumber: "8", RFCTitle: "Eight"},
+			},
+			peers: []PeerEntry{{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCs: 1}},
+		},
+		{
+			name: "record longer than a scanner line",
+			ops: func(fs *fileStore) error {
+				return errors.Join(
+					fs.AddPeer("p1", "10.0.0.1:40000", "5000"),
+					fs.AddRFC("p1", "7", longTitle, "", ""),
+				)
+			},
+			entries: []IndexEntry{
+				{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCNumber: "7", RFCTitle: longTitle},
+			},
+			peers: []PeerEntry{{PeerID: "p1", Hostname: "10.0.0.1:40000", UploadPort: "5000", RFCs: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, crashed := range []bool{true, false} {
+			name := tt.name + "/closed"
+			if crashed {
+				name = tt.name + "/crashed"
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				fs := openTestStore(t, dir)
+				if err := tt.ops(fs); err != nil {
+					t.Fatal(err)
+				}
+				if crashed {
+					crash(t, fs)
+				} else if err := fs.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+
+				reopened := openTestStore(t, dir)
+				defer crash(t, reopened)
+				if got := sortedEntries(reopened); !reflect.DeepEqual(got, tt.entries) {
+					t.Errorf("entries after reopening\n got: %+v\nwant: %+v", got, tt.entries)
+				}
+				if got := sortedPeers(reopened); !reflect.DeepEqual(got, tt.peers) {
+					t.Errorf("peers after reopening\n got: %+v\nwant: %+v", got, tt.peers)
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreCorruptLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    string
+		numbers []string
+	}{
+		{name: "torn last line", tail: `{"Op":"ADD_RFC","Hostname":"p1","RFC_Nu`, numbers: []string{"7"}},
+		{name: "torn line then a record", tail: "{\"Op\":\"ADD_RF\n" + `{"Op":"ADD_RFC","Hostname":"p1","RFC_Number":"9","RFC_Title":"Nine"}` + "\n", numbers: []string{"7", "9"}},
+		{name: "blank line", tail: "\n", numbers: []string{"7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fs := openTestStore(t, dir)
+			if err := errors.Join(fs.AddPeer("p1", "10.0.0.1:40000", "5000"), fs.AddRFC("p1", "7", "Seven", "", "")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fs.logFile.WriteString(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+			crash(t, fs)
+
+			reopened := openTestStore(t, dir)
+			defer crash(t, reopened)
+			numbers := []string{}
+			for _, entry := range sortedEntries(reopened) {
+				numbers = append(numbers, entry.RFCNumber)
+			}
+			if !slices.Equal(numbers, tt.numbers) {
+				t.Errorf("RFCs after replaying the log: got %v, want %v", numbers, tt.numbers)
+			}
+		})
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs := openTestStore(t, dir)
+	if err := fs.AddPeer("p1", "10.0.0.1:40000", "5000"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < StoreCompactionThreshold; i++ {
+		if err := fs.AddRFC("p1", strconv.Itoa(i), "Title", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The threshold was crossed, the log only holds what came after the snapshot
+	if fs.logRecords != 1 {
+		t.Errorf("log holds %d records after compaction, want 1", fs.logRecords)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Errorf("no snapshot after compaction: %v", err)
+	}
+	crash(t, fs)
+
+	reopened := openTestStore(t, dir)
+	defer crash(t, reopened)
+	if got := reopened.EntryCount(); got != StoreCompactionThreshold {
+		t.Errorf("reopened store holds %d RFCs, want %d", got, StoreCompactionThreshold)
+	}
+	if !reopened.restored["p1"] {
+		t.Error("peer of the snapshot not tracked as restored")
+	}
+}
@@ -2,20 +2,25 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
+	"strconv"
+	"time"
 
 	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
 	"P2P/common-helpers/data"
 )
 
 // sendErrorResponse sends an error response to the client
-func sendErrorResponse(conn net.Conn, code int, phrase string) error {
+func sendErrorResponse(conn *codec.Conn, req *request, code int, phrase string) error {
 	response := data.ServerResponse{
 		Header: data.ServerResponseHeader{
 			ResponseCode:             code,
 			ResponsePhrase:           phrase,
 			ServerApplicationVersion: ApplicationVersion,
+			RequestID:                req.ID,
 		},
 		Data: []data.ServerResponseData{},
 	}
@@ -25,20 +30,25 @@ func sendErrorResponse(conn net.Conn, code int, phrase string) error {
 		return fmt.Errorf("error serializing response: %w", err)
 	}
 
-	log.Printf("Error response created: %s", string(serialized))
-	serialized = append(serialized, '\n')
-	_, err = conn.Write(serialized)
-	log.Printf("Error response sent to client: %s", string(serialized))
-	return err
+	responsesTotal.Inc(strconv.Itoa(code))
+	req.Log.Info("Sending error response", "code", code, "phrase", phrase)
+	return conn.WriteFrame(common_helpers.ServerResponseIndex, serialized, nil)
 }
 
 // sendSuccessResponse sends a success response with data to the client
-func sendSuccessResponse(conn net.Conn, responseData []data.ServerResponseData) error {
+func sendSuccessResponse(conn *codec.Conn, req *request, responseData []data.ServerResponseData) error {
+	return sendPage(conn, req, responseData, "")
+}
+
+// sendPage sends a successful response holding one page of a listing, nextCursor is empty on the last page
+func sendPage(conn *codec.Conn, req *request, responseData []data.ServerResponseData, nextCursor string) error {
 	response := data.ServerResponse{
 		Header: data.ServerResponseHeader{
 			ResponseCode:             StatusOK,
 			ResponsePhrase:           "OK",
 			ServerApplicationVersion: ApplicationVersion,
+			RequestID:                req.ID,
+			NextCursor:               nextCursor,
 		},
 		Data: responseData,
 	}
@@ -48,268 +58,611 @@ func sendSuccessResponse(conn net.Conn, responseData []data.ServerResponseData)
 		return fmt.Errorf("error serializing response: %w", err)
 	}
 
-	serialized = append(serialized, '\n')
-	_, err = conn.Write(serialized)
-	return err
+	responsesTotal.Inc(strconv.Itoa(StatusOK))
+	req.Log.Debug("Sending response", "code", StatusOK, "entries", len(responseData))
+	return conn.WriteFrame(common_helpers.ServerResponseIndex, serialized, nil)
 }
 
-// rfcExists checks if an RFC already exists in the index for a given client
-func rfcExists(clientIP, rfcNumber, rfcTitle string) bool {
-	rfcIndexMapMutex.RLock()
-	defer rfcIndexMapMutex.RUnlock()
-
-	for _, rfcInfo := range rfcIndexMap[clientIP] {
-		if len(rfcInfo) == 2 && rfcInfo[0] == rfcNumber && rfcInfo[1] == rfcTitle {
-			return true
-		}
-	}
-	return false
+// rfcExists checks if an RFC with the same digest and manifest already exists in the index for a given peer
+func rfcExists(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) bool {
+	return indexStore.HasRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest)
 }
 
-// addRFCToIndex adds an RFC to the index for a given hostname
-func addRFCToIndex(hostname, rfcNumber, rfcTitle string) {
-	rfcIndexMapMutex.Lock()
-	defer rfcIndexMapMutex.Unlock()
-
-	// Initialize if needed
-	if _, ok := rfcIndexMap[hostname]; !ok {
-		rfcIndexMap[hostname] = make([][]string, 0)
+// addRFCToIndex adds an RFC to the index for a given peer
+func addRFCToIndex(req *request, peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) error {
+	if err := indexStore.AddRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest); err != nil {
+		return err
 	}
-
-	rfcIndexMap[hostname] = append(rfcIndexMap[hostname], []string{rfcNumber, rfcTitle})
-	log.Printf("Added RFC %s (%s) for host %s", rfcNumber, rfcTitle, hostname)
+	req.Log.Info("Added RFC to the index", "rfc", rfcNumber, "title", rfcTitle, "digest", rfcDigest)
+	return nil
 }
 
 // peerExists checks if a peer already exists in the peer info map
-func peerExists(clientIP string) bool {
-	peerInfoMapMutex.RLock()
-	defer peerInfoMapMutex.RUnlock()
-
-	_, exists := peerInfoMap[clientIP]
-	return exists
+func peerExists(peerID string) bool {
+	return indexStore.HasPeer(peerID)
 }
 
 // addPeerInfo adds peer information to the peer info map
-func addPeerInfo(hostname, uploadPort string) {
-	peerInfoMapMutex.Lock()
-	defer peerInfoMapMutex.Unlock()
-
-	peerInfoMap[hostname] = uploadPort
-	log.Printf("Added peer info for host %s on port %s", hostname, uploadPort)
+func addPeerInfo(req *request, peerID, hostname, uploadPort string) error {
+	if err := indexStore.AddPeer(peerID, hostname, uploadPort); err != nil {
+		return err
+	}
+	req.Log.Info("Added peer info", "upload_port", uploadPort)
+	return nil
 }
 
 // removePeerInfo removes peer information when a client disconnects
-func removePeerInfo(clientAddr string) {
-	peerInfoMapMutex.Lock()
-	defer peerInfoMapMutex.Unlock()
+func removePeerInfo(logger *slog.Logger, peerID string) {
+	if err := indexStore.RemovePeer(peerID); err != nil {
+		logger.Error("Error removing peer info", "error", err)
+		return
+	}
+	logger.Info("Removed peer info")
+}
 
-	delete(peerInfoMap, clientAddr)
-	log.Printf("Removed peer info for %s", clientAddr)
+// removeRFC withdraws a single RFC advertised by a peer
+func removeRFC(peerID, rfcNumber, rfcTitle string) ([]string, error) {
+	return indexStore.RemoveRFC(peerID, rfcNumber, rfcTitle)
 }
 
 // removeRFCIndex removes RFC index when a client disconnects
-func removeRFCIndex(clientAddr string) {
-	rfcIndexMapMutex.Lock()
-	defer rfcIndexMapMutex.Unlock()
-
-	delete(rfcIndexMap, clientAddr)
-	log.Printf("Removed RFC index for %s", clientAddr)
+func removeRFCIndex(logger *slog.Logger, peerID string) {
+	// The RFCs are read first, subscribers are told about every one the peer stops serving
+	removed := indexStore.PeerRFCs(peerID)
+	if err := indexStore.RemoveRFCs(peerID); err != nil {
+		logger.Error("Error removing RFC index", "error", err)
+		return
+	}
+	for _, entry := range removed {
+		subscribers.notify(common_helpers.EventRemoved, entry, "")
+	}
+	logger.Info("Removed RFC index")
 }
 
 // handleAddRequest processes an ADD request from a client
-func handleAddRequest(conn net.Conn, jsonData []byte) error {
+func handleAddRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
 	addStruct, err := DeserializeAddStruct(jsonData)
 	if err != nil {
-		log.Printf("Error deserializing AddStruct: %v", err)
-		return sendErrorResponse(conn, StatusBadRequest, "Bad Request")
+		req.Log.Warn("Error deserializing AddStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
 	}
 
-	log.Printf("ADD request: RFC %s (%s) from %s on upload port %s with application version %s",
-		addStruct.RFCNumber, addStruct.RFCTitle, addStruct.ClientIP, addStruct.ClientUploadPort, addStruct.ClientApplicationVersion)
+	req.Log.Info("ADD request", "rfc", addStruct.RFCNumber, "title", addStruct.RFCTitle,
+		"upload_port", addStruct.ClientUploadPort, "client_version", addStruct.ClientApplicationVersion)
+	session.checkClaimedAddress(req, addStruct.ClientIP)
 
 	// Validate application version
 	if addStruct.ClientApplicationVersion != ApplicationVersion {
-		log.Printf("Version mismatch: client=%s, server=%s",
-			addStruct.ClientApplicationVersion, ApplicationVersion)
-		return sendErrorResponse(conn, StatusVersionNotSupported, "P2P-CI Version Not Supported")
-	}
-  
-	// Check if RFC already exists 
-	if rfcExists(addStruct.ClientIP, addStruct.RFCNumber, addStruct.RFCTitle) {
-		log.Printf("RFC %s already exists for %s", addStruct.RFCNumber, addStruct.ClientIP)
-		// Still send success response
-		responseData := data.ServerResponseData{
-			RFCNumber:        addStruct.RFCNumber,
-			RFCTitle:         addStruct.RFCTitle,
-			ClientIP:         addStruct.ClientIP,
-			ClientUploadPort: addStruct.ClientUploadPort,
-		}
-		return sendSuccessResponse(conn, []data.ServerResponseData{responseData})
+		req.Log.Warn("Version mismatch", "client_version", addStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
 	}
 
-	// Add RFC to index
-	addRFCToIndex(addStruct.ClientIP, addStruct.RFCNumber, addStruct.RFCTitle)
+	if len(addStruct.RFCTitle) > MaxRFCTitleLength {
+		req.Log.Warn("Title too long", "rfc", addStruct.RFCNumber, "length", len(addStruct.RFCTitle), "max", MaxRFCTitleLength)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
 
-	// Add peer info if not already present
-	if !peerExists(addStruct.ClientIP) {
-		addPeerInfo(addStruct.ClientIP, addStruct.ClientUploadPort)
+	// Only authenticated publishers may add to the index
+	if code, phrase, ok := session.authorizePublish(); !ok {
+		req.Log.Warn("Refused ADD", "rfc", addStruct.RFCNumber, "reason", phrase)
+		return sendErrorResponse(conn, req, code, phrase)
+	}
+
+	// Validate the digest, it is optional for peers that do not compute one
+	if addStruct.RFCDigest != "" && !common_helpers.IsSHA256Digest(addStruct.RFCDigest) {
+		req.Log.Warn("Invalid digest", "rfc", addStruct.RFCNumber, "digest", addStruct.RFCDigest)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	// A signed manifest must describe this RFC and come from the publisher of the session
+	if code, phrase, ok := checkManifest(session, addStruct); !ok {
+		req.Log.Warn("Rejected manifest", "rfc", addStruct.RFCNumber, "reason", phrase)
+		return sendErrorResponse(conn, req, code, phrase)
 	}
 
-	// Send success response
 	responseData := data.ServerResponseData{
 		RFCNumber:        addStruct.RFCNumber,
 		RFCTitle:         addStruct.RFCTitle,
-		ClientIP:         addStruct.ClientIP,
+		ClientIP:         session.Address,
 		ClientUploadPort: addStruct.ClientUploadPort,
+		RFCDigest:        addStruct.RFCDigest,
+		RFCManifest:      addStruct.RFCManifest,
+	}
+
+	// An RFC announced again unchanged is still a success, but nothing changed for subscribers
+	if rfcExists(session.ID, addStruct.RFCNumber, addStruct.RFCTitle, addStruct.RFCDigest, addStruct.RFCManifest) {
+		req.Log.Info("RFC already exists for the peer", "rfc", addStruct.RFCNumber)
+		return sendSuccessResponse(conn, req, []data.ServerResponseData{responseData})
+	}
+
+	// Add RFC to index
+	if err := addRFCToIndex(req, session.ID, addStruct.RFCNumber, addStruct.RFCTitle, addStruct.RFCDigest, addStruct.RFCManifest); err != nil {
+		req.Log.Error("Error adding RFC to index", "rfc", addStruct.RFCNumber, "error", err)
+		return sendErrorResponse(conn, req, StatusInternalServerError, "Internal Server Error")
+	}
+
+	// Add peer info if not already present
+	if !peerExists(session.ID) {
+		if err := addPeerInfo(req, session.ID, session.Address, addStruct.ClientUploadPort); err != nil {
+			req.Log.Error("Error adding peer info", "error", err)
+			return sendErrorResponse(conn, req, StatusInternalServerError, "Internal Server Error")
+		}
+	}
+
+	// The RFC can be downloaded from now on
+	subscribers.notify(common_helpers.EventAdded, IndexEntry{
+		PeerID:      session.ID,
+		Hostname:    session.Address,
+		UploadPort:  addStruct.ClientUploadPort,
+		RFCNumber:   addStruct.RFCNumber,
+		RFCTitle:    addStruct.RFCTitle,
+		RFCDigest:   addStruct.RFCDigest,
+		RFCManifest: addStruct.RFCManifest,
+	}, req.ID)
+
+	return sendSuccessResponse(conn, req, []data.ServerResponseData{responseData})
+}
+
+// handleRemoveRequest processes a REMOVE request from a client, withdrawing one of its RFCs from the index
+func handleRemoveRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
+	removeStruct, err := DeserializeRemoveStruct(jsonData)
+	if err != nil {
+		req.Log.Warn("Error deserializing RemoveStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	req.Log.Info("REMOVE request", "rfc", removeStruct.RFCNumber, "title", removeStruct.RFCTitle,
+		"upload_port", removeStruct.ClientUploadPort, "client_version", removeStruct.ClientApplicationVersion)
+	session.checkClaimedAddress(req, removeStruct.ClientIP)
+
+	// Validate application version
+	if removeStruct.ClientApplicationVersion != ApplicationVersion {
+		req.Log.Warn("Version mismatch", "client_version", removeStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+	}
+
+	if code, phrase, ok := session.authorizePublish(); !ok {
+		req.Log.Warn("Refused REMOVE", "rfc", removeStruct.RFCNumber, "reason", phrase)
+		return sendErrorResponse(conn, req, code, phrase)
+	}
+
+	if removeStruct.RFCNumber == "" {
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	// Subscribers are told the address the RFCs were served from, not the one claimed in the request
+	withdrawn := []IndexEntry{}
+	for _, entry := range indexStore.PeerRFCs(session.ID) {
+		if entry.RFCNumber == removeStruct.RFCNumber && (removeStruct.RFCTitle == "" || entry.RFCTitle == removeStruct.RFCTitle) {
+			withdrawn = append(withdrawn, entry)
+		}
+	}
+
+	// Peers can only withdraw their own RFCs, an empty title withdraws the RFC number under every title
+	removedTitles, err := removeRFC(session.ID, removeStruct.RFCNumber, removeStruct.RFCTitle)
+	if err != nil {
+		req.Log.Error("Error removing RFC from index", "rfc", removeStruct.RFCNumber, "error", err)
+		return sendErrorResponse(conn, req, StatusInternalServerError, "Internal Server Error")
+	}
+	if len(removedTitles) == 0 {
+		req.Log.Info("RFC is not advertised by the peer", "rfc", removeStruct.RFCNumber)
+		return sendErrorResponse(conn, req, StatusNotFound, "Not Found")
+	}
+
+	// Send success response listing what was withdrawn
+	responseData := []data.ServerResponseData{}
+	for _, title := range removedTitles {
+		responseData = append(responseData, data.ServerResponseData{
+			RFCNumber:        removeStruct.RFCNumber,
+			RFCTitle:         title,
+			ClientIP:         session.Address,
+			ClientUploadPort: removeStruct.ClientUploadPort,
+		})
+	}
+	for _, entry := range withdrawn {
+		subscribers.notify(common_helpers.EventRemoved, entry, req.ID)
 	}
-	return sendSuccessResponse(conn, []data.ServerResponseData{responseData})
+	return sendSuccessResponse(conn, req, responseData)
+}
+
+// handleAuthRequest processes an AUTH request, binding the identity of the peer to its session
+func handleAuthRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
+	authStruct, err := DeserializeAuthStruct(jsonData)
+	if err != nil {
+		req.Log.Warn("Error deserializing AuthStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	req.Log.Info("AUTH request", "name", authStruct.Name, "auth_method", authStruct.Method)
+
+	// Validate application version
+	if authStruct.ClientApplicationVersion != ApplicationVersion {
+		req.Log.Warn("Version mismatch", "client_version", authStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+	}
+
+	// Without a keys file there is nothing to authenticate against, everyone may publish anyway
+	if authKeys == nil {
+		return sendSuccessResponse(conn, req, []data.ServerResponseData{})
+	}
+
+	credential, ok := authKeys.authenticate(session, authStruct)
+	if !ok {
+		req.Log.Warn("Authentication failed", "name", authStruct.Name)
+		authFailures.Inc()
+		return sendErrorResponse(conn, req, StatusUnauthorized, "Unauthorized")
+	}
+
+	session.Identity = credential.Name
+	session.CanPublish = credential.CanPublish
+	req.Log.Info("Peer authenticated", "identity", session.Identity, "can_publish", session.CanPublish)
+	return sendSuccessResponse(conn, req, []data.ServerResponseData{})
+}
+
+// handleHeartbeat processes a heartbeat from a client, the lease is already renewed by receiving it.
+// Heartbeats are never answered so they cannot get mixed up with the responses to requests.
+func handleHeartbeat(req *request, jsonData []byte) error {
+	heartbeat, err := DeserializeHeartbeatStruct(jsonData)
+	if err != nil {
+		req.Log.Warn("Error deserializing HeartbeatStruct", "error", err)
+		return nil
+	}
+
+	if heartbeat.ClientApplicationVersion != ApplicationVersion {
+		req.Log.Warn("Heartbeat with unsupported version", "client_version", heartbeat.ClientApplicationVersion)
+	}
+	req.Log.Debug("Lease renewed")
+	return nil
 }
 
 // handleLookupRequest processes a LOOKUP request from a client
-func handleLookupRequest(conn net.Conn, jsonData []byte) error {
+func handleLookupRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
 	lookUpStruct, err := DeserializeLookUpStruct(jsonData)
 	if err != nil {
-		log.Printf("Error deserializing LookUpStruct: %v", err)
-		return sendErrorResponse(conn, StatusBadRequest, "Bad Request")
+		req.Log.Warn("Error deserializing LookUpStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
 	}
 
-	log.Printf("LOOKUP request: RFC %s (%s) from %s:%s",
-		lookUpStruct.RFCNumber, lookUpStruct.RFCTitle,
-		lookUpStruct.ClientIP, lookUpStruct.ClientUploadPort)
+	req.Log.Info("LOOKUP request", "rfc", lookUpStruct.RFCNumber, "title", lookUpStruct.RFCTitle)
 
 	// Validate application version
 	if lookUpStruct.ClientApplicationVersion != ApplicationVersion {
-		log.Printf("Version mismatch: client=%s, server=%s",
-			lookUpStruct.ClientApplicationVersion, ApplicationVersion)
-		return sendErrorResponse(conn, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+		req.Log.Warn("Version mismatch", "client_version", lookUpStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+	}
+
+	if code, phrase, ok := session.authorizeRead(); !ok {
+		return sendErrorResponse(conn, req, code, phrase)
 	}
 
 	//We create an empty array of ServerResponseData
 	responseData := []data.ServerResponseData{}
 
-	// Iterate through the complete rfcIndexMap
-	for clientIP, rfcInfoArray := range rfcIndexMap {
-			
-		// Now iterate through each RFC pair for this clientIP
-		for _, rfcInfo := range rfcInfoArray {
-				
-				// Now we do a lookup in peerInfoMap to get the upload port
-				uploadPort, ok := peerInfoMap[clientIP]
-				if !ok {
-						log.Printf("Upload port not found for client %s", clientIP)
-						continue
-				}
-
-				// Now we add the RFC information to the responseData
-				if lookUpStruct.RFCNumber == rfcInfo[0] && lookUpStruct.RFCTitle == rfcInfo[1] {
-				responseData = append(responseData, data.ServerResponseData{
-						RFCNumber: rfcInfo[0],
-						RFCTitle: rfcInfo[1],
-						ClientIP: clientIP,
-						ClientUploadPort: uploadPort,
-				})
-			}
-		}
+	// Only the peers holding the RFC number are visited, an empty title matches every title
+	for _, entry := range indexStore.Lookup(lookUpStruct.RFCNumber, lookUpStruct.RFCTitle) {
+		responseData = append(responseData, data.ServerResponseData{
+			RFCNumber:        entry.RFCNumber,
+			RFCTitle:         entry.RFCTitle,
+			ClientIP:         entry.Hostname,
+			ClientUploadPort: entry.UploadPort,
+			RFCDigest:        entry.RFCDigest,
+			RFCManifest:      entry.RFCManifest,
+		})
 	}
 
 	//If the responseData is empty, we send an error response
 	if len(responseData) == 0 {
-		return sendErrorResponse(conn, StatusNotFound, "Not Found")
+		return sendErrorResponse(conn, req, StatusNotFound, "Not Found")
 	}
 
 	//Now we send the responseData to the client
-	return sendSuccessResponse(conn, responseData)
+	return sendSuccessResponse(conn, req, responseData)
 }
 
-// handleListRequest processes a LIST request from a client
-func handleListRequest(conn net.Conn, jsonData []byte) error {
+// handleSearchRequest processes a SEARCH request from a client, answered from the secondary indexes of the store
+func handleSearchRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
+	searchStruct, err := DeserializeSearchStruct(jsonData)
+	if err != nil {
+		req.Log.Warn("Error deserializing SearchStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	req.Log.Info("SEARCH request", "rfc", searchStruct.RFCNumber, "from", searchStruct.RFCNumberFrom, "to", searchStruct.RFCNumberTo,
+		"title", searchStruct.RFCTitle, "title_match", searchStruct.TitleMatch)
+
+	// Validate application version
+	if searchStruct.ClientApplicationVersion != ApplicationVersion {
+		req.Log.Warn("Version mismatch", "client_version", searchStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+	}
+
+	if code, phrase, ok := session.authorizeRead(); !ok {
+		return sendErrorResponse(conn, req, code, phrase)
+	}
+
+	query, err := parseSearchQuery(searchStruct)
+	if err != nil {
+		req.Log.Warn("Invalid SEARCH request", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	responseData := []data.ServerResponseData{}
+	for _, entry := range indexStore.Search(query) {
+		responseData = append(responseData, data.ServerResponseData{
+			RFCNumber:        entry.RFCNumber,
+			RFCTitle:         entry.RFCTitle,
+			ClientIP:         entry.Hostname,
+			ClientUploadPort: entry.UploadPort,
+			RFCDigest:        entry.RFCDigest,
+			RFCManifest:      entry.RFCManifest,
+		})
+	}
+
+	if len(responseData) == 0 {
+		return sendErrorResponse(conn, req, StatusNotFound, "Not Found")
+	}
+	return sendSuccessResponse(conn, req, responseData)
+}
+
+// handleSubscribeRequest processes a SUBSCRIBE request, the peer is then notified of the matching RFCs
+// added to or removed from the index until it disconnects
+func handleSubscribeRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
+	subscribeStruct, err := DeserializeSubscribeStruct(jsonData)
+	if err != nil {
+		req.Log.Warn("Error deserializing SubscribeStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	req.Log.Info("SUBSCRIBE request", "rfc", subscribeStruct.RFCNumber, "from", subscribeStruct.RFCNumberFrom, "to", subscribeStruct.RFCNumberTo,
+		"title", subscribeStruct.RFCTitle, "title_match", subscribeStruct.TitleMatch)
+
+	// Validate application version
+	if subscribeStruct.ClientApplicationVersion != ApplicationVersion {
+		req.Log.Warn("Version mismatch", "client_version", subscribeStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+	}
+
+	if code, phrase, ok := session.authorizeRead(); !ok {
+		return sendErrorResponse(conn, req, code, phrase)
+	}
+
+	// Subscriptions select RFCs the way searches do
+	query, err := parseSearchQuery(data.SearchStruct{
+		RFCNumber:     subscribeStruct.RFCNumber,
+		RFCNumberFrom: subscribeStruct.RFCNumberFrom,
+		RFCNumberTo:   subscribeStruct.RFCNumberTo,
+		RFCTitle:      subscribeStruct.RFCTitle,
+		TitleMatch:    subscribeStruct.TitleMatch,
+	})
+	if err != nil {
+		req.Log.Warn("Invalid SUBSCRIBE request", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	count := subscribers.subscribe(session, conn, query)
+	req.Log.Info("Subscribed", "subscriptions", count)
+	return sendSuccessResponse(conn, req, []data.ServerResponseData{})
+}
+
+// parseSearchQuery checks a SEARCH request and turns it into a query of the store.
+// A single number is a range of one, a range may leave either end open, and at least a number or a title is needed.
+func parseSearchQuery(searchStruct data.SearchStruct) (SearchQuery, error) {
+	query := SearchQuery{
+		FirstNumber: 0,
+		LastNumber:  math.MaxInt,
+		Title:       searchStruct.RFCTitle,
+		TitleMatch:  searchStruct.TitleMatch,
+	}
+
+	if searchStruct.RFCNumber != "" {
+		if searchStruct.RFCNumberFrom != "" || searchStruct.RFCNumberTo != "" {
+			return query, fmt.Errorf("RFC_Number cannot be combined with a range")
+		}
+		searchStruct.RFCNumberFrom = searchStruct.RFCNumber
+		searchStruct.RFCNumberTo = searchStruct.RFCNumber
+	}
+	if searchStruct.RFCNumberFrom != "" {
+		first, err := parseRFCNumber(searchStruct.RFCNumberFrom)
+		if err != nil {
+			return query, err
+		}
+		query.FirstNumber = first
+		query.HasNumbers = true
+	}
+	if searchStruct.RFCNumberTo != "" {
+		last, err := parseRFCNumber(searchStruct.RFCNumberTo)
+		if err != nil {
+			return query, err
+		}
+		query.LastNumber = last
+		query.HasNumbers = true
+	}
+	if query.FirstNumber > query.LastNumber {
+		return query, fmt.Errorf("empty RFC number range %d-%d", query.FirstNumber, query.LastNumber)
+	}
+
+	switch query.TitleMatch {
+	case "":
+		query.TitleMatch = TitleMatchSubstring
+	case TitleMatchSubstring, TitleMatchPrefix:
+	default:
+		return query, fmt.Errorf("unknown Title_Match %q", query.TitleMatch)
+	}
+
+	if !query.HasNumbers && query.Title == "" {
+		return query, fmt.Errorf("search needs an RFC number, a range or a title")
+	}
+	return query, nil
+}
+
+// parseRFCNumber parses an RFC number of a SEARCH request
+func parseRFCNumber(value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("RFC number %q is not a number", value)
+	}
+	return number, nil
+}
+
+// handleListRequest processes a LIST request from a client.
+// The index is sent a page at a time, the next page is asked for with the cursor of the previous one
+// or, in stream mode, sent right after it until the last page.
+func handleListRequest(conn *codec.Conn, session *peerSession, req *request, jsonData []byte) error {
 	listStruct, err := DeserializeListStruct(jsonData)
 	if err != nil {
-		log.Printf("Error deserializing ListStruct: %v", err)
-		return sendErrorResponse(conn, StatusBadRequest, "Bad Request")
+		req.Log.Warn("Error deserializing ListStruct", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
 	}
 
-	log.Printf("LIST request from %s:%s with application version %s",
-		listStruct.ClientIP, listStruct.ClientUploadPort, listStruct.ClientApplicationVersion)
+	req.Log.Info("LIST request", "client_version", listStruct.ClientApplicationVersion,
+		"limit", listStruct.Limit, "mode", listStruct.Mode, "resumed", listStruct.Cursor != "")
 
 	// Validate application version
 	if listStruct.ClientApplicationVersion != ApplicationVersion {
-		log.Printf("Version mismatch: client=%s, server=%s",
-			listStruct.ClientApplicationVersion, ApplicationVersion)
-		return sendErrorResponse(conn, StatusVersionNotSupported, "P2P-CI Version Not Supported")
+		req.Log.Warn("Version mismatch", "client_version", listStruct.ClientApplicationVersion, "server_version", ApplicationVersion)
+		return sendErrorResponse(conn, req, StatusVersionNotSupported, "P2P-CI Version Not Supported")
 	}
 
-	//We create an empty array of ServerResponseData
-	responseData := []data.ServerResponseData{}
+	if code, phrase, ok := session.authorizeRead(); !ok {
+		return sendErrorResponse(conn, req, code, phrase)
+	}
+
+	limit, err := parseListLimit(listStruct.Limit)
+	if err != nil {
+		req.Log.Warn("Invalid LIST request", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+	cursor, err := parseListCursor(listStruct.Cursor)
+	if err != nil {
+		req.Log.Warn("Invalid LIST request", "error", err)
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+	stream := false
+	switch listStruct.Mode {
+	case "", common_helpers.ListModePages:
+	case common_helpers.ListModeStream:
+		stream = true
+	default:
+		req.Log.Warn("Invalid LIST request", "error", fmt.Errorf("unknown Mode %q", listStruct.Mode))
+		return sendErrorResponse(conn, req, StatusBadRequest, "Bad Request")
+	}
+
+	for {
+		// Every page is read on its own, ADDs are not held up by a long stream
+		entries, last, more := indexStore.List(cursor, limit)
+
+		//We create an empty array of ServerResponseData
+		responseData := []data.ServerResponseData{}
+		for _, entry := range entries {
+			responseData = append(responseData, data.ServerResponseData{
+				RFCNumber:        entry.RFCNumber,
+				RFCTitle:         entry.RFCTitle,
+				ClientIP:         entry.Hostname,
+				ClientUploadPort: entry.UploadPort,
+				RFCDigest:        entry.RFCDigest,
+				RFCManifest:      entry.RFCManifest,
+			})
+		}
 
-	// Iterate through the complete rfcIndexMap
-	for clientIP, rfcInfoArray := range rfcIndexMap {
-			
-		// Now iterate through each RFC pair for this clientIP
-		for _, rfcInfo := range rfcInfoArray {
-				
-				// Now we do a lookup in peerInfoMap to get the upload port
-				uploadPort, ok := peerInfoMap[clientIP]
-				if !ok {
-						log.Printf("Upload port not found for client %s", clientIP)
-						continue
-				}
-
-				// Now we add the RFC information to the responseData
-				responseData = append(responseData, data.ServerResponseData{
-						RFCNumber: rfcInfo[0],
-						RFCTitle: rfcInfo[1],
-						ClientIP: clientIP,
-						ClientUploadPort: uploadPort,
-				})
+		nextCursor := ""
+		if more {
+			nextCursor = last.String()
+		}
+		if err := sendPage(conn, req, responseData, nextCursor); err != nil {
+			return err
 		}
+		if !more || !stream {
+			return nil
+		}
+		cursor = last
 	}
+}
 
-	//Now we send the responseData to the client
-	return sendSuccessResponse(conn, responseData)
+// parseListLimit reads the page size of a LIST request, capped to MaxListPageSize
+func parseListLimit(value string) (int, error) {
+	if value == "" {
+		return DefaultListPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid Limit %q", value)
+	}
+	return min(limit, MaxListPageSize), nil
 }
 
 // handleClientMessages listens for and processes messages from a client connection
-func handleClientMessages(conn net.Conn, dedicatedPort string) {
-	defer conn.Close()
-	defer common_helpers.ReturnPort(dedicatedPort)
-	defer removeRFCIndex(conn.RemoteAddr().String())
-	defer removePeerInfo(conn.RemoteAddr().String())
+func handleClientMessages(clientConn net.Conn, dedicatedPort string, session *peerSession) {
+	defer clientConn.Close()
+	// Clients in single-port mode have no dedicated port to give back
+	if dedicatedPort != "" {
+		defer common_helpers.ReturnPort(dedicatedPort)
+	}
+	session.Log = serverLog.With("peer_id", session.ID, "address", session.Address)
+	// The RFCs go before the peer info, so the REMOVED notifications still carry its address
+	defer removePeerInfo(session.Log, session.ID)
+	defer removeRFCIndex(session.Log, session.ID)
+	defer leases.release(session.ID)
+	defer subscribers.release(session.ID)
+
+	activeSessions.Inc()
+	defer activeSessions.Dec()
+
+	// The peer picks the encoding, we answer in whatever it speaks
+	conn, err := codec.Accept(clientConn)
+	if err != nil {
+		session.Log.Warn("Error reading message", "error", err)
+		return
+	}
+	session.Log.Info("Session started", "encoding", conn.Codec.Name())
 
-	reader := common_helpers.NewMessageReader(conn)
 	for {
-		message, err := reader.ReadMessage()
+		frame, err := conn.ReadFrame()
 		if err != nil {
-			log.Printf("Error reading message from %s: %v", conn.RemoteAddr(), err)
+			session.Log.Info("Session ended", "error", err)
 			return
 		}
 
+		// Any message shows the peer is alive
+		leases.renew(session.ID, clientConn)
+
 		// Extract message type and payload
-		if len(message) < 2 {
-			log.Printf("Invalid message length from %s", conn.RemoteAddr())
-			continue
-		}
+		structTypeInt := int(frame.Type)
+		jsonData := frame.Header
 
-		structTypeInt := int(message[0])
-		jsonData := message[1 : len(message)-1]
+		method := requestMethod(structTypeInt)
+		requestsTotal.Inc(method)
+		started := time.Now()
+		req := newRequest(session, method, jsonData)
 
 		// Route to appropriate handler
 		var handleErr error
 		switch structTypeInt {
 		case common_helpers.AddStructIndex:
-			handleErr = handleAddRequest(conn, jsonData)
+			handleErr = handleAddRequest(conn, session, req, jsonData)
 		case common_helpers.LookupStructIndex:
-			handleErr = handleLookupRequest(conn, jsonData)
+			handleErr = handleLookupRequest(conn, session, req, jsonData)
 		case common_helpers.ListStructIndex:
-			handleErr = handleListRequest(conn, jsonData)
+			handleErr = handleListRequest(conn, session, req, jsonData)
+		case common_helpers.SearchIndex:
+			handleErr = handleSearchRequest(conn, session, req, jsonData)
+		case common_helpers.SubscribeIndex:
+			handleErr = handleSubscribeRequest(conn, session, req, jsonData)
+		case common_helpers.RemoveStructIndex:
+			handleErr = handleRemoveRequest(conn, session, req, jsonData)
+		case common_helpers.AuthIndex:
+			handleErr = handleAuthRequest(conn, session, req, jsonData)
+		case common_helpers.HeartbeatIndex:
+			handleErr = handleHeartbeat(req, jsonData)
 		default:
-			log.Printf("Unknown message type %d from %s", structTypeInt, conn.RemoteAddr())
+			req.Log.Warn("Unknown message type", "type", structTypeInt)
 			continue
 		}
+		requestDuration.Observe(time.Since(started).Seconds(), method)
 
 		if handleErr != nil {
-			log.Printf("Error handling request: %v", handleErr)
+			req.Log.Error("Error handling request", "error", handleErr)
 			return
 		}
+		req.Log.Debug("Request handled", "duration", time.Since(started))
 	}
 }
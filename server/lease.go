@@ -0,0 +1,100 @@
+// This file expires the index entries of peers that stop sending heartbeats
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// lease records until when a peer is considered alive
+type lease struct {
+	expires time.Time
+
+	// conn is closed when the lease expires so the handler of a half-open connection returns.
+	// It is nil for peers restored from disk that have not reconnected.
+	conn net.Conn
+}
+
+// leaseTable holds a lease for every peer in the index.
+// Any message from a peer renews its lease, heartbeats keep idle peers alive.
+type leaseTable struct {
+	mu       sync.Mutex
+	leases   map[string]*lease
+	duration time.Duration
+}
+
+// newLeaseTable creates an empty lease table granting leases of the given duration
+func newLeaseTable(duration time.Duration) *leaseTable {
+	return &leaseTable{
+		leases:   make(map[string]*lease),
+		duration: duration,
+	}
+}
+
+// renew extends the lease of a peer, granting one if it has none
+func (lt *leaseTable) renew(peerID string, conn net.Conn) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.leases[peerID] = &lease{expires: time.Now().Add(lt.duration), conn: conn}
+}
+
+// release drops the lease of a peer whose connection closed
+func (lt *leaseTable) release(peerID string) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	delete(lt.leases, peerID)
+}
+
+// expiry returns when the lease of a peer runs out, if it has one
+func (lt *leaseTable) expiry(peerID string) (time.Time, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	l, ok := lt.leases[peerID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return l.expires, true
+}
+
+// sweep evicts every peer whose lease expired before now
+func (lt *leaseTable) sweep(now time.Time) {
+	lt.mu.Lock()
+	expired := make(map[string]*lease)
+	for peerID, l := range lt.leases {
+		if now.After(l.expires) {
+			expired[peerID] = l
+			delete(lt.leases, peerID)
+		}
+	}
+	lt.mu.Unlock()
+
+	for peerID, l := range expired {
+		logger := serverLog.With("peer_id", peerID)
+		logger.Warn("Lease expired, evicting the peer from the index")
+		leaseEvictions.Inc()
+		removeRFCIndex(logger, peerID)
+		removePeerInfo(logger, peerID)
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	}
+}
+
+// runLeaseSweeper sweeps the lease table a few times per lease until stop is closed
+func runLeaseSweeper(lt *leaseTable, stop <-chan struct{}) {
+	ticker := time.NewTicker(lt.duration / LeaseSweepsPerDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			lt.sweep(now)
+		}
+	}
+}
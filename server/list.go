@@ -0,0 +1,73 @@
+// This file defines the cursors LIST responses are paged with
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ListCursor is the position of an RFC in listings, which are ordered by RFC number, title and the order RFCs were added in.
+// Peers get it encoded as an opaque string and send it back as is to get the next page.
+type ListCursor struct {
+	RFCNumber string `json:"n"`
+	RFCTitle  string `json:"t"`
+
+	// Seq orders the RFCs sharing a number and title, it is not kept across restarts of the server
+	// so a cursor from before a restart may repeat or skip the holders of one RFC
+	Seq uint64 `json:"s"`
+}
+
+// isStart checks if the cursor is the zero cursor, which starts from the first RFC
+func (c ListCursor) isStart() bool {
+	return c == ListCursor{}
+}
+
+// String encodes the cursor for the Next_Cursor of a response
+func (c ListCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseListCursor decodes the Cursor of a LIST request, an empty cursor starts from the first RFC
+func parseListCursor(value string) (ListCursor, error) {
+	var cursor ListCursor
+	if value == "" {
+		return cursor, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, fmt.Errorf("error decoding cursor: %w", err)
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return cursor, fmt.Errorf("error decoding cursor: %w", err)
+	}
+	if cursor.isStart() {
+		return cursor, fmt.Errorf("cursor does not point to an RFC")
+	}
+	return cursor, nil
+}
+
+// compareListCursors orders two positions in listings
+func compareListCursors(a, b ListCursor) int {
+	return cmp.Or(compareRFCNumbers(a.RFCNumber, b.RFCNumber), cmp.Compare(a.RFCTitle, b.RFCTitle), cmp.Compare(a.Seq, b.Seq))
+}
+
+// compareRFCNumbers orders RFC numbers numerically, anything that is not a number comes after the numbers
+func compareRFCNumbers(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		// Leading zeros make different numbers of the same value, they still need a stable order
+		return cmp.Or(cmp.Compare(an, bn), cmp.Compare(a, b))
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"P2P/common-helpers/codec"
+	"P2P/common-helpers/data"
+)
+
+// newListRegistry fills a registry with RFCs whose numbers and titles sort differently as strings and as numbers
+func newListRegistry() *rfcRegistry {
+	rr := newRFCRegistry()
+	for p := 0; p < 3; p++ {
+		peerID := fmt.Sprintf("peer-%d", p)
+		rr.AddPeer(peerID, fmt.Sprintf("10.0.0.%d:40000", p), "50000")
+	}
+	for n := 1; n <= 12; n++ {
+		number := strconv.Itoa(n)
+		for p := 0; p < 3; p++ {
+			if (n+p)%2 == 0 {
+				rr.AddRFC(fmt.Sprintf("peer-%d", p), number, "Title "+number, "", "")
+			}
+		}
+		if n%4 == 0 {
+			rr.AddRFC("peer-1", number, "Another title", "", "")
+		}
+	}
+	rr.AddRFC("peer-2", "draft", "Not a number", "", "")
+	return rr
+}
+
+// listAll reads every page of a listing from cursor on
+func listAll(t *testing.T, rr *rfcRegistry, cursor ListCursor, limit int) []IndexEntry {
+	t.Helper()
+
+	entries := []IndexEntry{}
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("listing never ends")
+		}
+		page, last, more := rr.List(cursor, limit)
+		if len(page) > limit {
+			t.Fatalf("page of %d entries, limit %d", len(page), limit)
+		}
+		entries = append(entries, page...)
+		if !more {
+			return entries
+		}
+		// The cursor goes through its encoding like it does between a peer and the server
+		parsed, err := parseListCursor(last.String())
+		if err != nil {
+			t.Fatalf("parseListCursor: %v", err)
+		}
+		cursor = parsed
+	}
+}
+
+func TestListStableOrder(t *testing.T) {
+	rr := newListRegistry()
+	all, _, more := rr.List(ListCursor{}, MaxListPageSize)
+	if more {
+		t.Fatal("whole index does not fit a page")
+	}
+	if len(all) != rr.EntryCount() {
+		t.Fatalf("listed %d entries, index holds %d", len(all), rr.EntryCount())
+	}
+
+	// Numbers in numeric order, then titles, then the order the RFCs were added in
+	for i := 1; i < len(all); i++ {
+		a, b := all[i-1], all[i]
+		if order := compareRFCNumbers(a.RFCNumber, b.RFCNumber); order > 0 || order == 0 && a.RFCTitle > b.RFCTitle {
+			t.Errorf("entry %d (%s %q) listed before entry %d (%s %q)", i-1, a.RFCNumber, a.RFCTitle, i, b.RFCNumber, b.RFCTitle)
+		}
+	}
+	if last := all[len(all)-1]; last.RFCNumber != "draft" {
+		t.Errorf("last entry is RFC %s, want the one that is not a number", last.RFCNumber)
+	}
+
+	for _, limit := range []int{1, 2, 3, 5, len(all) - 1, len(all), len(all) + 1} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			if got := listAll(t, rr, ListCursor{}, limit); !reflect.DeepEqual(got, all) {
+				t.Errorf("pages do not add up to the listing\n got: %+v\nwant: %+v", got, all)
+			}
+		})
+	}
+}
+
+func TestListResumeAfterRemovals(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(rr *rfcRegistry, last IndexEntry)
+	}{
+		{
+			name: "last listed RFC removed",
+			ops: func(rr *rfcRegistry, last IndexEntry) {
+				rr.RemoveRFC(last.PeerID, last.RFCNumber, last.RFCTitle)
+			},
+		},
+		{
+			name: "every holder of the last listed number removed",
+			ops: func(rr *rfcRegistry, last IndexEntry) {
+				for _, entry := range rr.Lookup(last.RFCNumber, "") {
+					rr.RemoveRFC(entry.PeerID, entry.RFCNumber, "")
+				}
+			},
+		},
+		{
+			name: "RFCs of the next page removed",
+			ops: func(rr *rfcRegistry, last IndexEntry) {
+				rr.RemoveRFC("peer-0", "8", "")
+				rr.RemoveRFC("peer-1", "9", "")
+			},
+		},
+		{
+			name: "peer removed",
+			ops: func(rr *rfcRegistry, last IndexEntry) {
+				rr.RemoveRFCs("peer-1")
+				rr.RemovePeer("peer-1")
+			},
+		},
+		{
+			name: "listed RFC re-added",
+			ops: func(rr *rfcRegistry, last IndexEntry) {
+				rr.RemoveRFC(last.PeerID, last.RFCNumber, last.RFCTitle)
+				rr.AddRFC(last.PeerID, last.RFCNumber, last.RFCTitle, "", "")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := newListRegistry()
+			first, cursor, more := rr.List(ListCursor{}, 10)
+			if !more {
+				t.Fatal("first page holds the whole index")
+			}
+
+			tt.ops(rr, first[len(first)-1])
+
+			// The rest of the listing is whatever is still there past the cursor, none of it skipped or repeated
+			want := []IndexEntry{}
+			for _, entry := range listAll(t, rr, ListCursor{}, MaxListPageSize) {
+				if compareListCursors(listCursorOf(t, rr, entry), cursor) > 0 {
+					want = append(want, entry)
+				}
+			}
+			if got := listAll(t, rr, cursor, 3); !reflect.DeepEqual(got, want) {
+				t.Errorf("listing resumed after the first page\n got: %+v\nwant: %+v", got, want)
+			}
+		})
+	}
+}
+
+// listCursorOf returns the position of a listed entry
+func listCursorOf(t *testing.T, rr *rfcRegistry, entry IndexEntry) ListCursor {
+	t.Helper()
+
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	record, ok := rr.byPeer[entry.PeerID][rfcKey{PeerID: entry.PeerID, RFCNumber: entry.RFCNumber, RFCTitle: entry.RFCTitle}]
+	if !ok {
+		t.Fatalf("listed entry %+v not in the index", entry)
+	}
+	return record.cursor()
+}
+
+func TestParseListLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		limit int
+		err   bool
+	}{
+		{value: "", limit: DefaultListPageSize},
+		{value: "1", limit: 1},
+		{value: strconv.Itoa(MaxListPageSize), limit: MaxListPageSize},
+		{value: strconv.Itoa(MaxListPageSize + 1), limit: MaxListPageSize},
+		{value: "1000000", limit: MaxListPageSize},
+		{value: "0", err: true},
+		{value: "-5", err: true},
+		{value: "ten", err: true},
+	}
+
+	for _, tt := range tests {
+		limit, err := parseListLimit(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseListLimit(%q) = %d, want an error", tt.value, limit)
+			}
+			continue
+		}
+		if err != nil || limit != tt.limit {
+			t.Errorf("parseListLimit(%q) = %d, %v, want %d", tt.value, limit, err, tt.limit)
+		}
+	}
+}
+
+func TestListMalformedCursor(t *testing.T) {
+	defer func(saved IndexStore) { indexStore = saved }(indexStore)
+	indexStore = newMemoryStore()
+	indexStore.AddPeer("peer-0", "10.0.0.1:40000", "50000")
+	indexStore.AddRFC("peer-0", "7", "Seven", "", "")
+
+	tests := []struct {
+		name   string
+		cursor string
+		code   int
+	}{
+		{name: "valid", cursor: ListCursor{RFCNumber: "1", RFCTitle: "One", Seq: 1}.String(), code: StatusOK},
+		{name: "not base64", cursor: "not a cursor!", code: StatusBadRequest},
+		{name: "not JSON", cursor: "bm90IGpzb24", code: StatusBadRequest},
+		{name: "zero cursor", cursor: ListCursor{}.String(), code: StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverEnd, peerEnd := net.Pipe()
+			defer peerEnd.Close()
+
+			session := &peerSession{ID: "peer-1", Log: serverLog}
+			req := &request{ID: "list", Log: serverLog}
+			listStruct, _ := json.Marshal(data.ListStruct{ClientApplicationVersion: ApplicationVersion, Cursor: tt.cursor})
+			go func() {
+				defer serverEnd.Close()
+				handleListRequest(codec.NewConn(serverEnd, codec.JSON), session, req, listStruct)
+			}()
+
+			frame, err := codec.NewConn(peerEnd, codec.JSON).ReadFrame()
+			if err != nil {
+				t.Fatalf("reading LIST response: %v", err)
+			}
+			var response data.ServerResponse
+			if err := json.Unmarshal(frame.Header, &response); err != nil {
+				t.Fatalf("decoding LIST response: %v", err)
+			}
+			if response.Header.ResponseCode != tt.code {
+				t.Errorf("LIST with cursor %q answered %d %s, want %d", tt.cursor, response.Header.ResponseCode, response.Header.ResponsePhrase, tt.code)
+			}
+		})
+	}
+}
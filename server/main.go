@@ -1,13 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	common_helpers "P2P/common-helpers"
 
@@ -18,61 +23,158 @@ var (
 	port          string
 	clientCounter int
 
-	// peerInfoMap stores mapping of hostname to upload port
-	peerInfoMap      = make(map[string]string)
-	peerInfoMapMutex sync.RWMutex
+	// serverLog is the structured logger every session and request logger derives from,
+	// the default logger until main configures it
+	serverLog = slog.Default()
 
-	// rfcIndexMap stores RFC information indexed by hostname
-	// Each entry is a slice of [RFC_Number, RFC_Title] pairs
-	rfcIndexMap      = make(map[string][][]string)
-	rfcIndexMapMutex sync.RWMutex
+	// indexStore stores the peer info and RFC index served by the handlers
+	indexStore IndexStore
+
+	// tlsConfig secures every listener when TLS is configured, nil for plain TCP
+	tlsConfig *tls.Config
+
+	// authKeys holds the credentials peers authenticate with, nil when anyone may publish
+	authKeys *authRegistry
+
+	// leases evicts peers from the index that stop sending heartbeats
+	leases *leaseTable
+
+	// subscribers holds the subscriptions notified of changes to the index
+	subscribers = newSubscriberTable()
 )
 
-// createServerAcceptConnectionsSocket creates the main listener for accepting client connections
-func createServerAcceptConnectionsSocket() (net.Listener, error) {
+// listen opens a TCP listener on a port, wrapped in TLS when it is configured
+func listen(port string) (net.Listener, error) {
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil {
+		return tls.NewListener(listener, tlsConfig), nil
+	}
 	return listener, nil
 }
 
-// handleClientConnection manages the dedicated connection for a single client
+// createServerAcceptConnectionsSocket creates the main listener for accepting client connections
+func createServerAcceptConnectionsSocket() (net.Listener, error) {
+	return listen(port)
+}
+
+// readConnectionMode reads the handshake a peer opens its connection with and reports whether the peer announced it.
+// Peers predating single-port mode send nothing and wait for their dedicated port, they are only
+// told apart once HandshakeTimeout passed without a handshake.
+func readConnectionMode(conn net.Conn) (string, bool, error) {
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	line, err := common_helpers.ReadHandshakeLine(conn)
+	var netErr net.Error
+	if line == "" && errors.As(err, &netErr) && netErr.Timeout() {
+		return common_helpers.ConnectionModeDedicated, false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error reading handshake: %w", err)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != common_helpers.HandshakeHello {
+		return "", false, fmt.Errorf("malformed handshake %q", line)
+	}
+	if fields[1] != common_helpers.ConnectionModeSingle && fields[1] != common_helpers.ConnectionModeDedicated {
+		return "", false, fmt.Errorf("unknown connection mode %q", fields[1])
+	}
+	return fields[1], true, nil
+}
+
+// handleClientConnection sets up the session of a new client, either on the accepted
+// connection itself or on a dedicated port if the client asks for the legacy mode
 func handleClientConnection(conn net.Conn, clientID int) error {
+	logger := serverLog.With("client", clientID, "address", conn.RemoteAddr().String())
+
+	mode, announced, err := readConnectionMode(conn)
+	if err != nil {
+		conn.Close()
+		logger.Warn("Error in handshake", "error", err)
+		return err
+	}
+
+	// The peer ID binds everything the client publishes to this session
+	session, err := newPeerSession()
+	if err != nil {
+		conn.Close()
+		logger.Error("Error creating session", "error", err)
+		return err
+	}
+
+	if mode == common_helpers.ConnectionModeDedicated {
+		return handleDedicatedConnection(conn, logger, session, announced)
+	}
+
+	// From here on the accepted connection carries the protocol
+	session.Address = conn.RemoteAddr().String()
+	if _, err := conn.Write([]byte(session.ID + "\n")); err != nil {
+		conn.Close()
+		logger.Warn("Error sending peer ID to client", "error", err)
+		return err
+	}
+
+	logger.Info("Client assigned peer ID on the server port", "peer_id", session.ID)
+	go handleClientMessages(conn, "", session)
+
+	return nil
+}
+
+// handleDedicatedConnection moves a client onto a dedicated port allocated from the pool.
+// Peers that announced the mode are also told their peer ID, silent ones only read the port as they always did.
+func handleDedicatedConnection(conn net.Conn, logger *slog.Logger, session *peerSession, announced bool) error {
 	// Allocate a dedicated port for this client
 	dedicatedPort, err := common_helpers.GetFreePort()
 	if err != nil {
-		log.Printf("Error getting free port for client %d: %v", clientID, err)
+		logger.Error("Error getting free port", "error", err)
 		return err
 	}
 
-	log.Printf("Client %d assigned dedicated port %s", clientID, dedicatedPort)
+	logger = logger.With("dedicated_port", dedicatedPort)
+	logger.Info("Client assigned dedicated port", "peer_id", session.ID)
 
 	// Create dedicated listener on the allocated port
-	dedicatedListener, err := net.Listen("tcp", ":"+dedicatedPort)
+	dedicatedListener, err := listen(dedicatedPort)
 	if err != nil {
-		log.Printf("Error creating dedicated socket on port %s: %v", dedicatedPort, err)
+		logger.Error("Error creating dedicated socket", "error", err)
 		return err
 	}
 	defer dedicatedListener.Close()
 
-	// Inform client of their dedicated port
-	if _, err := conn.Write([]byte(dedicatedPort + "\n")); err != nil {
-		log.Printf("Error sending port to client: %v", err)
+	// Inform client of their dedicated port and peer ID
+	assignment := dedicatedPort
+	if announced {
+		assignment += " " + session.ID
+	}
+	if _, err := conn.Write([]byte(assignment + "\n")); err != nil {
+		logger.Warn("Error sending port to client", "error", err)
 		return err
 	}
 
 	// Accept connection from client on dedicated port
 	clientConn, err := dedicatedListener.Accept()
 	if err != nil {
-		log.Printf("Error accepting client connection on dedicated port: %v", err)
+		logger.Warn("Error accepting client connection on dedicated port", "error", err)
 		return err
 	}
 
-	log.Printf("Client %d connected on dedicated port %s", clientID, dedicatedPort)
+	// Only the client that was given the port may take it
+	if hostIP(clientConn.RemoteAddr().String()) != hostIP(conn.RemoteAddr().String()) {
+		clientConn.Close()
+		common_helpers.ReturnPort(dedicatedPort)
+		logger.Warn("Rejected connection from another host on the dedicated port", "remote_address", clientConn.RemoteAddr().String())
+		return fmt.Errorf("dedicated port %s taken by another host", dedicatedPort)
+	}
+	session.Address = clientConn.RemoteAddr().String()
+
+	logger.Info("Client connected on dedicated port")
 
 	// Handle messages from this client in a goroutine
-	go handleClientMessages(clientConn, dedicatedPort)
+	go handleClientMessages(clientConn, dedicatedPort, session)
 
 	return nil
 }
@@ -84,33 +186,108 @@ func acceptConnectionsFromClients(listener net.Listener) error {
 		if err != nil {
 			// Check if the error is due to listener being closed (expected during shutdown)
 			if strings.Contains(err.Error(), "use of closed network connection") {
-				log.Println("Listener closed, shutting down accept loop")
+				serverLog.Info("Listener closed, shutting down accept loop")
 				return nil
 			}
-			log.Printf("Error accepting connection: %v", err)
+			serverLog.Error("Error accepting connection", "error", err)
 			return err
 		}
 
 		clientCounter++
-		log.Printf("New connection from %s (client #%d)", conn.RemoteAddr(), clientCounter)
+		serverLog.Info("New connection", "address", conn.RemoteAddr().String(), "client", clientCounter)
 
 		go handleClientConnection(conn, clientCounter)
 	}
 }
 
 func main() {
-	// Load environment variables
-	if err := godotenv.Load("../.env"); err != nil {
-		log.Println("Warning: .env file not found in parent directory")
+	var err error
+
+	// Load environment variables, reported once the logger they may configure is set up
+	envErr := godotenv.Load("../.env")
+
+	// Every log line goes through the structured logger, at the level asked for
+	serverLog, err = common_helpers.NewLogger("server")
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	if envErr != nil {
+		serverLog.Warn(".env file not found in parent directory")
 	}
 
 	// Get server port from environment or use default
 	port = os.Getenv("SERVER_CONNECTIONS_PORT")
 	if port == "" {
-		log.Printf("Using default port %s", DefaultServerPort)
+		serverLog.Info("Using default port", "port", DefaultServerPort)
 		port = DefaultServerPort
 	}
 
+	// TLS is optional, without it everything runs over plain TCP
+	tlsSettings, err := common_helpers.LoadTLSSettings()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if tlsSettings.Enabled() {
+		tlsConfig, err = tlsSettings.ServerConfig()
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		serverLog.Info("TLS enabled", "client_auth", tlsSettings.ClientAuth)
+	}
+
+	// Publishing needs credentials once a keys file is configured
+	if keysFile := os.Getenv("AUTH_KEYS_FILE"); keysFile != "" {
+		anonymousRead := true
+		if value := os.Getenv("AUTH_ANONYMOUS_READ"); value != "" {
+			anonymousRead, err = strconv.ParseBool(value)
+			if err != nil {
+				log.Fatalf("Invalid AUTH_ANONYMOUS_READ %q: %v", value, err)
+			}
+		}
+		authKeys, err = loadAuthRegistry(keysFile, anonymousRead)
+		if err != nil {
+			log.Fatalf("Failed to load keys file: %v", err)
+		}
+		serverLog.Info("Authentication enabled", "registered_peers", len(authKeys.credentials), "anonymous_read", anonymousRead)
+	}
+
+	// Open the index store, restoring the index from disk if it is persisted
+	storeKind := os.Getenv("INDEX_STORE")
+	if storeKind == "" {
+		storeKind = IndexStoreFile
+	}
+	storeDir := os.Getenv("INDEX_STORE_DIR")
+	if storeDir == "" {
+		storeDir = DefaultIndexStoreDir
+	}
+	indexStore, err = openIndexStore(storeKind, storeDir)
+	if err != nil {
+		log.Fatalf("Failed to open index store: %v", err)
+	}
+
+	// Peers must keep renewing their lease to stay in the index
+	leaseDuration := DefaultPeerLeaseDuration
+	if value := os.Getenv("PEER_LEASE_DURATION"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			serverLog.Warn("Invalid PEER_LEASE_DURATION, using the default", "value", value, "default", DefaultPeerLeaseDuration)
+		} else {
+			leaseDuration = parsed
+		}
+	}
+	leases = newLeaseTable(leaseDuration)
+
+	// Peers restored from disk get one lease to reconnect in, then they are evicted.
+	// Peers that withdrew all their RFCs are restored too, and need a lease to ever leave.
+	for _, peerID := range indexStore.PeerIDs() {
+		leases.renew(peerID, nil)
+	}
+
+	stopSweeper := make(chan struct{})
+	defer close(stopSweeper)
+	go runLeaseSweeper(leases, stopSweeper)
+	serverLog.Info("Peers are evicted without a heartbeat", "lease_duration", leaseDuration)
+
 	// Create main listener for client connections
 	listener, err := createServerAcceptConnectionsSocket()
 	if err != nil {
@@ -118,12 +295,23 @@ func main() {
 	}
 	defer listener.Close()
 
-	log.Printf("P2P Server %s running on port %s", ApplicationVersion, port)
+	serverLog.Info("P2P Server running", "version", ApplicationVersion, "port", port)
+
+	// Serve the admin API for dashboards and scripts
+	adminAddr := os.Getenv("ADMIN_HTTP_ADDR")
+	if adminAddr == "" {
+		adminAddr = DefaultAdminAddress
+	}
+	if adminAddr != AdminDisabled {
+		adminServer := startAdminServer(adminAddr, time.Now())
+		defer adminServer.Close()
+		serverLog.Info("Admin API listening", "address", adminAddr)
+	}
 
 	// Start accepting connections in background
 	go func() {
 		if err := acceptConnectionsFromClients(listener); err != nil {
-			log.Printf("Accept loop error: %v", err)
+			serverLog.Error("Accept loop error", "error", err)
 		}
 	}()
 
@@ -132,5 +320,8 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
-	log.Println("Shutting down server...")
+	serverLog.Info("Shutting down server...")
+	if err := indexStore.Close(); err != nil {
+		serverLog.Error("Error closing index store", "error", err)
+	}
 }
@@ -0,0 +1,56 @@
+// This file defines the metrics of the server, exposed on /metrics of the admin API
+package main
+
+import (
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/metrics"
+)
+
+var (
+	// serverMetrics holds every metric of the server
+	serverMetrics = metrics.NewRegistry()
+
+	requestsTotal   = serverMetrics.NewCounter("p2p_server_requests_total", "Requests received from peers, by method.", "method")
+	responsesTotal  = serverMetrics.NewCounter("p2p_server_responses_total", "Responses sent to peers, by status code.", "code")
+	requestDuration = serverMetrics.NewHistogram("p2p_server_request_duration_seconds", "Time spent handling a request, by method.", metrics.DurationBuckets, "method")
+	activeSessions  = serverMetrics.NewGauge("p2p_server_sessions", "Peer connections currently open.")
+	leaseEvictions  = serverMetrics.NewCounter("p2p_server_lease_evictions_total", "Peers evicted from the index because their lease expired.")
+	authFailures    = serverMetrics.NewCounter("p2p_server_auth_failures_total", "AUTH requests with invalid credentials.")
+	notifications   = serverMetrics.NewCounter("p2p_server_notifications_total", "Notifications queued for subscribed peers, by event.", "event")
+	droppedEvents   = serverMetrics.NewCounter("p2p_server_notifications_dropped_total", "Notifications dropped because the queue of the peer was full.")
+
+	// requestMethods names the request frames in the metrics
+	requestMethods = map[int]string{
+		common_helpers.AddStructIndex:    "ADD",
+		common_helpers.LookupStructIndex: "LOOKUP",
+		common_helpers.ListStructIndex:   "LIST",
+		common_helpers.RemoveStructIndex: "REMOVE",
+		common_helpers.HeartbeatIndex:    "HEARTBEAT",
+		common_helpers.AuthIndex:         "AUTH",
+		common_helpers.SearchIndex:       "SEARCH",
+		common_helpers.SubscribeIndex:    "SUBSCRIBE",
+	}
+)
+
+func init() {
+	serverMetrics.NewGaugeFunc("p2p_server_peers", "Peers in the index.", func() float64 {
+		return float64(indexStore.PeerCount())
+	})
+	serverMetrics.NewGaugeFunc("p2p_server_index_entries", "RFC entries in the index.", func() float64 {
+		return float64(indexStore.EntryCount())
+	})
+	serverMetrics.NewGaugeFunc("p2p_server_port_pool_free", "Dedicated ports left in the pool.", func() float64 {
+		return float64(common_helpers.FreePortCount())
+	})
+	serverMetrics.NewGaugeFunc("p2p_server_port_pool_size", "Dedicated ports in the pool when the server started.", func() float64 {
+		return float64(common_helpers.MaxPortRange - common_helpers.MinPortRange)
+	})
+}
+
+// requestMethod names a request frame type in the metrics
+func requestMethod(frameType int) string {
+	if method, ok := requestMethods[frameType]; ok {
+		return method
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,485 @@
+// This file implements the registry of peers and the RFCs they advertise
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// peerRecord is a peer known to the registry
+type peerRecord struct {
+	// Hostname is the address the server saw the peer connect from, empty for peers restored from
+	// indexes persisted before peer IDs existed, those are keyed by that address instead
+	Hostname   string
+	UploadPort string
+}
+
+// rfcRecord is an RFC advertised by a peer
+type rfcRecord struct {
+	PeerID    string
+	RFCNumber string
+	RFCTitle  string
+	RFCDigest string
+
+	// RFCManifest is the encoded manifest signed by the publisher, empty for unsigned RFCs
+	RFCManifest string
+
+	// seq orders the records sharing a number and title in listings, it is assigned when the record is added
+	seq uint64
+}
+
+// key returns the key the record is indexed by
+func (r *rfcRecord) key() rfcKey {
+	return rfcKey{PeerID: r.PeerID, RFCNumber: r.RFCNumber, RFCTitle: r.RFCTitle}
+}
+
+// cursor returns the position of the record in listings
+func (r *rfcRecord) cursor() ListCursor {
+	return ListCursor{RFCNumber: r.RFCNumber, RFCTitle: r.RFCTitle, Seq: r.seq}
+}
+
+// rfcRegistry holds the peers and their RFCs behind a single lock.
+// Every index is updated under the write lock, so readers always see them agree with each other.
+type rfcRegistry struct {
+	mu sync.RWMutex
+
+	// peers holds every peer with a known upload port
+	peers map[string]peerRecord
+
+	// byPeer holds the RFCs advertised by every peer, a peer may have RFCs without being in peers
+	// while it is removed or after it was restored from disk
+	byPeer map[string]map[rfcKey]*rfcRecord
+
+	// byNumber holds the RFCs advertised under every RFC number, LOOKUP is answered from it.
+	// numbers keeps those numbers sorted by compareRFCNumbers for LIST pages.
+	byNumber map[string]map[rfcKey]*rfcRecord
+	numbers  []string
+
+	// lastSeq is the seq of the last record added
+	lastSeq uint64
+
+	// search indexes the RFCs by number range and title for SEARCH
+	search *searchIndex
+}
+
+// newRFCRegistry creates an empty registry
+func newRFCRegistry() *rfcRegistry {
+	return &rfcRegistry{
+		peers:    make(map[string]peerRecord),
+		byPeer:   make(map[string]map[rfcKey]*rfcRecord),
+		byNumber: make(map[string]map[rfcKey]*rfcRecord),
+		search:   newSearchIndex(),
+	}
+}
+
+func (rr *rfcRegistry) AddRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.addLocked(&rfcRecord{PeerID: peerID, RFCNumber: rfcNumber, RFCTitle: rfcTitle, RFCDigest: rfcDigest, RFCManifest: rfcManifest})
+	return nil
+}
+
+// addLocked indexes an RFC, the caller must hold rr.mu for writing
+func (rr *rfcRegistry) addLocked(record *rfcRecord) {
+	key := record.key()
+
+	// A changed file is re-announced under the same number and title
+	if existing, ok := rr.byPeer[record.PeerID][key]; ok {
+		existing.RFCDigest = record.RFCDigest
+		existing.RFCManifest = record.RFCManifest
+		return
+	}
+
+	rr.lastSeq++
+	record.seq = rr.lastSeq
+
+	if rr.byPeer[record.PeerID] == nil {
+		rr.byPeer[record.PeerID] = make(map[rfcKey]*rfcRecord)
+	}
+	rr.byPeer[record.PeerID][key] = record
+	if rr.byNumber[record.RFCNumber] == nil {
+		rr.byNumber[record.RFCNumber] = make(map[rfcKey]*rfcRecord)
+		i, _ := slices.BinarySearchFunc(rr.numbers, record.RFCNumber, compareRFCNumbers)
+		rr.numbers = slices.Insert(rr.numbers, i, record.RFCNumber)
+	}
+	rr.byNumber[record.RFCNumber][key] = record
+	rr.search.add(key)
+}
+
+// removeLocked drops an RFC from every index, the caller must hold rr.mu for writing
+func (rr *rfcRegistry) removeLocked(record *rfcRecord) {
+	key := record.key()
+
+	delete(rr.byPeer[record.PeerID], key)
+	if len(rr.byPeer[record.PeerID]) == 0 {
+		delete(rr.byPeer, record.PeerID)
+	}
+	delete(rr.byNumber[record.RFCNumber], key)
+	if len(rr.byNumber[record.RFCNumber]) == 0 {
+		delete(rr.byNumber, record.RFCNumber)
+		if i, found := slices.BinarySearchFunc(rr.numbers, record.RFCNumber, compareRFCNumbers); found {
+			rr.numbers = slices.Delete(rr.numbers, i, i+1)
+		}
+	}
+	rr.search.remove(key)
+}
+
+func (rr *rfcRegistry) HasRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) bool {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	record, ok := rr.byPeer[peerID][rfcKey{PeerID: peerID, RFCNumber: rfcNumber, RFCTitle: rfcTitle}]
+	return ok && record.RFCDigest == rfcDigest && record.RFCManifest == rfcManifest
+}
+
+func (rr *rfcRegistry) AddPeer(peerID, hostname, uploadPort string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	peer := peerRecord{Hostname: hostname, UploadPort: uploadPort}
+	if hostname == "" {
+		peer.Hostname = rr.peers[peerID].Hostname
+	}
+	rr.peers[peerID] = peer
+	return nil
+}
+
+func (rr *rfcRegistry) HasPeer(peerID string) bool {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	_, exists := rr.peers[peerID]
+	return exists
+}
+
+func (rr *rfcRegistry) RemovePeer(peerID string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	delete(rr.peers, peerID)
+	return nil
+}
+
+func (rr *rfcRegistry) RemoveRFC(peerID, rfcNumber, rfcTitle string) ([]string, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	removed := []string{}
+	for _, record := range rr.matchingLocked(peerID, rfcNumber, rfcTitle) {
+		removed = append(removed, record.RFCTitle)
+		rr.removeLocked(record)
+	}
+	return removed, nil
+}
+
+// matchingTitles returns the titles of an RFC advertised by a peer, any title matches an empty title
+func (rr *rfcRegistry) matchingTitles(peerID, rfcNumber, rfcTitle string) []string {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	titles := []string{}
+	for _, record := range rr.matchingLocked(peerID, rfcNumber, rfcTitle) {
+		titles = append(titles, record.RFCTitle)
+	}
+	return titles
+}
+
+// matchingLocked returns the records of an RFC advertised by a peer, any title matches an empty title.
+// The caller must hold rr.mu.
+func (rr *rfcRegistry) matchingLocked(peerID, rfcNumber, rfcTitle string) []*rfcRecord {
+	records := []*rfcRecord{}
+	if rfcTitle != "" {
+		if record, ok := rr.byPeer[peerID][rfcKey{PeerID: peerID, RFCNumber: rfcNumber, RFCTitle: rfcTitle}]; ok {
+			records = append(records, record)
+		}
+		return records
+	}
+
+	// A peer advertises few titles per number, the number index is smaller than the peer index
+	for key, record := range rr.byNumber[rfcNumber] {
+		if key.PeerID == peerID {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (rr *rfcRegistry) RemoveRFCs(peerID string) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	for _, record := range rr.byPeer[peerID] {
+		rr.removeLocked(record)
+	}
+	return nil
+}
+
+func (rr *rfcRegistry) PeerRFCs(peerID string) []IndexEntry {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	entries := []IndexEntry{}
+	for _, record := range rr.byPeer[peerID] {
+		entries = append(entries, rr.entryLocked(record))
+	}
+	return entries
+}
+
+func (rr *rfcRegistry) Entries() []IndexEntry {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	entries := []IndexEntry{}
+	for peerID, records := range rr.byPeer {
+		// Peers without a known upload port cannot serve anything
+		if _, ok := rr.peers[peerID]; !ok {
+			continue
+		}
+		for _, record := range records {
+			entries = append(entries, rr.entryLocked(record))
+		}
+	}
+	return entries
+}
+
+func (rr *rfcRegistry) Peers() []PeerEntry {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	peers := []PeerEntry{}
+	for peerID := range rr.peers {
+		peers = append(peers, rr.peerLocked(peerID))
+	}
+	return peers
+}
+
+func (rr *rfcRegistry) EntryCount() int {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	count := 0
+	for peerID, records := range rr.byPeer {
+		if _, ok := rr.peers[peerID]; ok {
+			count += len(records)
+		}
+	}
+	return count
+}
+
+func (rr *rfcRegistry) PeerCount() int {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	return len(rr.peers)
+}
+
+func (rr *rfcRegistry) Lookup(rfcNumber, rfcTitle string) []IndexEntry {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	entries := []IndexEntry{}
+	for _, record := range rr.byNumber[rfcNumber] {
+		if rfcTitle != "" && record.RFCTitle != rfcTitle {
+			continue
+		}
+		if _, ok := rr.peers[record.PeerID]; !ok {
+			continue
+		}
+		entries = append(entries, rr.entryLocked(record))
+	}
+
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return cmp.Or(cmp.Compare(a.RFCTitle, b.RFCTitle), cmp.Compare(a.PeerID, b.PeerID))
+	})
+	return entries
+}
+
+func (rr *rfcRegistry) List(cursor ListCursor, limit int) ([]IndexEntry, ListCursor, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	// Only the numbers from the one the cursor stopped at are visited
+	start := 0
+	if !cursor.isStart() {
+		start, _ = slices.BinarySearchFunc(rr.numbers, cursor.RFCNumber, compareRFCNumbers)
+	}
+
+	entries := []IndexEntry{}
+	last := cursor
+	for _, number := range rr.numbers[start:] {
+		records := make([]*rfcRecord, 0, len(rr.byNumber[number]))
+		for _, record := range rr.byNumber[number] {
+			records = append(records, record)
+		}
+		slices.SortFunc(records, func(a, b *rfcRecord) int {
+			return compareListCursors(a.cursor(), b.cursor())
+		})
+
+		for _, record := range records {
+			if !cursor.isStart() && compareListCursors(record.cursor(), cursor) <= 0 {
+				continue
+			}
+			if _, ok := rr.peers[record.PeerID]; !ok {
+				continue
+			}
+			if len(entries) == limit {
+				return entries, last, true
+			}
+			entries = append(entries, rr.entryLocked(record))
+			last = record.cursor()
+		}
+	}
+	return entries, last, false
+}
+
+func (rr *rfcRegistry) Search(query SearchQuery) []IndexEntry {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	entries := []IndexEntry{}
+	for key := range rr.search.search(query) {
+		if _, ok := rr.peers[key.PeerID]; !ok {
+			continue
+		}
+		if record, ok := rr.byPeer[key.PeerID][key]; ok {
+			entries = append(entries, rr.entryLocked(record))
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		an, _ := strconv.Atoi(a.RFCNumber)
+		bn, _ := strconv.Atoi(b.RFCNumber)
+		return cmp.Or(cmp.Compare(an, bn), cmp.Compare(a.RFCTitle, b.RFCTitle), cmp.Compare(a.PeerID, b.PeerID))
+	})
+	return entries
+}
+
+// peer returns a peer known to the registry
+func (rr *rfcRegistry) peer(peerID string) (PeerEntry, bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	_, ok := rr.peers[peerID]
+	return rr.peerLocked(peerID), ok
+}
+
+func (rr *rfcRegistry) PeerIDs() []string {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	peerIDs := []string{}
+	for peerID := range rr.peers {
+		peerIDs = append(peerIDs, peerID)
+	}
+	for peerID := range rr.byPeer {
+		if _, ok := rr.peers[peerID]; !ok {
+			peerIDs = append(peerIDs, peerID)
+		}
+	}
+	return peerIDs
+}
+
+// entryLocked copies a record out of the registry, the caller must hold rr.mu
+func (rr *rfcRegistry) entryLocked(record *rfcRecord) IndexEntry {
+	peer := rr.peerLocked(record.PeerID)
+	return IndexEntry{
+		PeerID:      record.PeerID,
+		Hostname:    peer.Hostname,
+		UploadPort:  peer.UploadPort,
+		RFCNumber:   record.RFCNumber,
+		RFCTitle:    record.RFCTitle,
+		RFCDigest:   record.RFCDigest,
+		RFCManifest: record.RFCManifest,
+	}
+}
+
+// peerLocked copies a peer out of the registry, the caller must hold rr.mu
+func (rr *rfcRegistry) peerLocked(peerID string) PeerEntry {
+	peer := rr.peers[peerID]
+	hostname := peer.Hostname
+	if hostname == "" {
+		hostname = peerID
+	}
+	return PeerEntry{
+		PeerID:     peerID,
+		Hostname:   hostname,
+		UploadPort: peer.UploadPort,
+		RFCs:       len(rr.byPeer[peerID]),
+	}
+}
+
+// snapshot converts the registry to the layout persisted by the file store
+func (rr *rfcRegistry) snapshot() storeSnapshot {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	snapshot := storeSnapshot{
+		PeerInfo: make(map[string]string),
+		PeerHost: make(map[string]string),
+		RFCIndex: make(map[string][][]string),
+	}
+	for peerID, peer := range rr.peers {
+		snapshot.PeerInfo[peerID] = peer.UploadPort
+		if peer.Hostname != "" {
+			snapshot.PeerHost[peerID] = peer.Hostname
+		}
+	}
+	for peerID, records := range rr.byPeer {
+		for _, record := range records {
+			snapshot.RFCIndex[peerID] = append(snapshot.RFCIndex[peerID],
+				[]string{record.RFCNumber, record.RFCTitle, record.RFCDigest, record.RFCManifest})
+		}
+	}
+	return snapshot
+}
+
+// restore replaces the content of the registry with a persisted snapshot
+func (rr *rfcRegistry) restore(snapshot storeSnapshot) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.peers = make(map[string]peerRecord)
+	rr.byPeer = make(map[string]map[rfcKey]*rfcRecord)
+	rr.byNumber = make(map[string]map[rfcKey]*rfcRecord)
+	rr.numbers = nil
+	rr.search = newSearchIndex()
+
+	for peerID, uploadPort := range snapshot.PeerInfo {
+		rr.peers[peerID] = peerRecord{Hostname: snapshot.PeerHost[peerID], UploadPort: uploadPort}
+	}
+	for peerID, rfcInfoArray := range snapshot.RFCIndex {
+		for _, rfcInfo := range rfcInfoArray {
+			if len(rfcInfo) < 2 {
+				serverLog.Warn("Skipping malformed index entry", "peer_id", peerID, "entry", rfcInfo)
+				continue
+			}
+			rr.addLocked(&rfcRecord{
+				PeerID:      peerID,
+				RFCNumber:   rfcInfo[0],
+				RFCTitle:    rfcInfo[1],
+				RFCDigest:   digestOf(rfcInfo),
+				RFCManifest: manifestOf(rfcInfo),
+			})
+		}
+	}
+}
+
+// digestOf returns the digest of a persisted index entry, empty for entries stored without one
+func digestOf(rfcInfo []string) string {
+	if len(rfcInfo) < 3 {
+		return ""
+	}
+	return rfcInfo[2]
+}
+
+// manifestOf returns the manifest of a persisted index entry, empty for entries stored without one.
+// Entries persisted before manifests existed hold triples, before digests existed [RFC_Number, RFC_Title] pairs.
+func manifestOf(rfcInfo []string) string {
+	if len(rfcInfo) < 4 {
+		return ""
+	}
+	return rfcInfo[3]
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// registrySizes are the total numbers of RFCs in the registries benchmarked
+var registrySizes = []int{1_000, 10_000, 100_000}
+
+// rfcsPerPeer is how many RFCs every benchmarked peer advertises
+const rfcsPerPeer = 100
+
+// newBenchmarkRegistry fills a registry with size RFCs, every RFC number held by the same number of peers
+func newBenchmarkRegistry(size int) *rfcRegistry {
+	rr := newRFCRegistry()
+	peers := size / rfcsPerPeer
+	for p := 0; p < peers; p++ {
+		peerID := fmt.Sprintf("peer-%d", p)
+		rr.AddPeer(peerID, fmt.Sprintf("10.0.%d.%d:40000", p/256, p%256), "50000")
+		for i := 0; i < rfcsPerPeer; i++ {
+			number := strconv.Itoa((p*rfcsPerPeer + i) % (size / 4))
+			rr.AddRFC(peerID, number, "Title of RFC "+number, "digest", "")
+		}
+	}
+	return rr
+}
+
+// scanLookup answers a LOOKUP with a full scan of the index, in the order Lookup returns entries
+func scanLookup(rr *rfcRegistry, rfcNumber, rfcTitle string) []IndexEntry {
+	entries := []IndexEntry{}
+	for _, entry := range rr.Entries() {
+		if entry.RFCNumber == rfcNumber && (rfcTitle == "" || entry.RFCTitle == rfcTitle) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return cmp.Or(cmp.Compare(a.RFCTitle, b.RFCTitle), cmp.Compare(a.PeerID, b.PeerID))
+	})
+	return entries
+}
+
+func TestLookupMatchesScan(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(rr *rfcRegistry)
+	}{
+		{
+			name: "fresh",
+			ops:  func(rr *rfcRegistry) {},
+		},
+		{
+			name: "second title",
+			ops: func(rr *rfcRegistry) {
+				rr.AddRFC("peer-0", "42", "Other title", "digest", "")
+				rr.AddRFC("peer-1", "42", "Other title", "digest", "")
+			},
+		},
+		{
+			name: "removed title",
+			ops: func(rr *rfcRegistry) {
+				rr.AddRFC("peer-0", "42", "Other title", "digest", "")
+				rr.RemoveRFC("peer-0", "42", "Title of RFC 42")
+			},
+		},
+		{
+			name: "removed number",
+			ops: func(rr *rfcRegistry) {
+				rr.AddRFC("peer-0", "42", "Other title", "digest", "")
+				rr.RemoveRFC("peer-0", "42", "")
+				rr.RemoveRFC("peer-1", "43", "")
+			},
+		},
+		{
+			name: "removed last holder",
+			ops: func(rr *rfcRegistry) {
+				for _, peerID := range []string{"peer-0", "peer-2", "peer-5", "peer-7"} {
+					rr.RemoveRFC(peerID, "0", "")
+				}
+			},
+		},
+		{
+			name: "removed peer keeping its RFCs",
+			ops: func(rr *rfcRegistry) {
+				rr.RemovePeer("peer-3")
+			},
+		},
+		{
+			name: "removed peer and RFCs",
+			ops: func(rr *rfcRegistry) {
+				rr.RemoveRFCs("peer-3")
+				rr.RemovePeer("peer-3")
+			},
+		},
+		{
+			name: "re-added after removal",
+			ops: func(rr *rfcRegistry) {
+				rr.RemoveRFCs("peer-3")
+				rr.RemovePeer("peer-3")
+				rr.AddPeer("peer-3", "10.0.0.3:40001", "50001")
+				rr.AddRFC("peer-3", "42", "Title of RFC 42", "new digest", "")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 10 peers holding RFCs 0 to 249, every number held by 4 of them
+			rr := newBenchmarkRegistry(1_000)
+			tt.ops(rr)
+
+			for n := 0; n <= 250; n++ {
+				number := strconv.Itoa(n)
+				for _, title := range []string{"", "Title of RFC " + number, "Other title"} {
+					got, want := rr.Lookup(number, title), scanLookup(rr, number, title)
+					if !reflect.DeepEqual(got, want) {
+						t.Fatalf("Lookup(%q, %q)\n got: %+v\nwant: %+v", number, title, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLookupAfterRemovals(t *testing.T) {
+	rr := newBenchmarkRegistry(1_000)
+	holders := func(rfcNumber string) []string {
+		peerIDs := []string{}
+		for _, entry := range rr.Lookup(rfcNumber, "") {
+			peerIDs = append(peerIDs, entry.PeerID)
+		}
+		return peerIDs
+	}
+
+	if got, want := holders("42"), []string{"peer-0", "peer-2", "peer-5", "peer-7"}; !slices.Equal(got, want) {
+		t.Fatalf("holders of RFC 42: got %v, want %v", got, want)
+	}
+
+	rr.RemoveRFC("peer-2", "42", "")
+	if got, want := holders("42"), []string{"peer-0", "peer-5", "peer-7"}; !slices.Equal(got, want) {
+		t.Errorf("holders of RFC 42 after RemoveRFC: got %v, want %v", got, want)
+	}
+
+	// A peer without an upload port cannot serve its RFCs, even before they are removed
+	rr.RemovePeer("peer-5")
+	if got, want := holders("42"), []string{"peer-0", "peer-7"}; !slices.Equal(got, want) {
+		t.Errorf("holders of RFC 42 after RemovePeer: got %v, want %v", got, want)
+	}
+
+	rr.RemoveRFC("peer-0", "42", "")
+	rr.RemoveRFCs("peer-5")
+	rr.RemoveRFCs("peer-7")
+	if got := holders("42"); len(got) != 0 {
+		t.Errorf("holders of RFC 42 after removing every holder: got %v, want none", got)
+	}
+	if slices.Contains(rr.numbers, "42") {
+		t.Error("RFC 42 still listed after its last holder removed it")
+	}
+}
+
+// BenchmarkLookup shows LOOKUP only pays for the peers holding the RFC, not for the size of the index
+func BenchmarkLookup(b *testing.B) {
+	for _, size := range registrySizes {
+		rr := newBenchmarkRegistry(size)
+		b.Run(fmt.Sprintf("rfcs=%d", size), func(b *testing.B) {
+			for b.Loop() {
+				if entries := rr.Lookup("42", ""); len(entries) != 4 {
+					b.Fatalf("expected 4 holders of RFC 42, got %d", len(entries))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLookupScan is the full scan of the index LOOKUP used to do, for comparison
+func BenchmarkLookupScan(b *testing.B) {
+	for _, size := range registrySizes {
+		rr := newBenchmarkRegistry(size)
+		b.Run(fmt.Sprintf("rfcs=%d", size), func(b *testing.B) {
+			for b.Loop() {
+				holders := 0
+				for _, entry := range rr.Entries() {
+					if entry.RFCNumber == "42" {
+						holders++
+					}
+				}
+				if holders != 4 {
+					b.Fatalf("expected 4 holders of RFC 42, got %d", holders)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLookupParallel runs LOOKUPs while another goroutine keeps adding and removing RFCs
+func BenchmarkLookupParallel(b *testing.B) {
+	for _, size := range registrySizes {
+		rr := newBenchmarkRegistry(size)
+		b.Run(fmt.Sprintf("rfcs=%d", size), func(b *testing.B) {
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					number := strconv.Itoa(size + i%1000)
+					rr.AddRFC("writer", number, "Churn", "digest", "")
+					rr.RemoveRFC("writer", number, "")
+				}
+			}()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rr.Lookup("42", "")
+				}
+			})
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// This file gives every request the ID it is traced by across the logs of the server and the peers
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	common_helpers "P2P/common-helpers"
+)
+
+// request is a request being handled on a session
+type request struct {
+	// ID is chosen by the peer so its own logs and those of the uploading peer can be matched with ours,
+	// the server picks one for peers that do not send it
+	ID string
+
+	// Log tags every message about the request with its ID, method and session
+	Log *slog.Logger
+}
+
+// newRequest reads the ID of a request frame and builds the logger of the request
+func newRequest(session *peerSession, method string, header []byte) *request {
+	var traced struct {
+		RequestID string `json:"Request_ID"`
+	}
+	json.Unmarshal(header, &traced)
+
+	id := traced.RequestID
+	if id == "" {
+		id = common_helpers.NewRequestID()
+	}
+	return &request{
+		ID:  id,
+		Log: session.Log.With("request_id", id, "method", method),
+	}
+}
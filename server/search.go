@@ -0,0 +1,254 @@
+// This file keeps the secondary indexes SEARCH requests are answered from
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ways a SEARCH request matches titles
+const (
+	TitleMatchSubstring = "substring"
+	TitleMatchPrefix    = "prefix"
+)
+
+// trigramLength is the length of the title fragments substring searches are indexed by
+const trigramLength = 3
+
+// SearchQuery selects index entries by RFC number range and title, every set field must match
+type SearchQuery struct {
+	// HasNumbers restricts the search to RFC numbers from FirstNumber to LastNumber, both included
+	HasNumbers  bool
+	FirstNumber int
+	LastNumber  int
+
+	// Title matches titles case-insensitively, as a substring or a prefix depending on TitleMatch
+	Title      string
+	TitleMatch string
+}
+
+// matches checks if a single RFC is selected by the query, without going through the indexes
+func (q SearchQuery) matches(rfcNumber, rfcTitle string) bool {
+	if q.HasNumbers {
+		number, err := strconv.Atoi(rfcNumber)
+		if err != nil || number < q.FirstNumber || number > q.LastNumber {
+			return false
+		}
+	}
+	if q.Title == "" {
+		return true
+	}
+
+	title := strings.ToLower(rfcTitle)
+	if q.TitleMatch == TitleMatchPrefix {
+		return strings.HasPrefix(title, strings.ToLower(q.Title))
+	}
+	return strings.Contains(title, strings.ToLower(q.Title))
+}
+
+// rfcKey identifies an RFC advertised by a peer in the secondary indexes
+type rfcKey struct {
+	PeerID    string
+	RFCNumber string
+	RFCTitle  string
+}
+
+// keySet is a set of RFCs advertised by peers
+type keySet map[rfcKey]struct{}
+
+// searchIndex indexes the RFCs of the store by number and by title so SEARCH never scans the whole index
+type searchIndex struct {
+	// byNumber holds the RFCs of every number, numbers keeps those numbers sorted for range queries
+	byNumber map[int]keySet
+	numbers  []int
+
+	// byTitle holds the RFCs of every lowercased title, titles keeps those titles sorted for prefix queries
+	byTitle map[string]keySet
+	titles  []string
+
+	// trigrams maps every fragment of trigramLength bytes to the lowercased titles containing it
+	trigrams map[string]map[string]struct{}
+}
+
+// newSearchIndex creates empty secondary indexes
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		byNumber: make(map[int]keySet),
+		byTitle:  make(map[string]keySet),
+		trigrams: make(map[string]map[string]struct{}),
+	}
+}
+
+// add indexes an RFC advertised by a peer
+func (si *searchIndex) add(key rfcKey) {
+	// RFC numbers are checked by the peers, anything else can only be found by title
+	if number, err := strconv.Atoi(key.RFCNumber); err == nil {
+		if _, ok := si.byNumber[number]; !ok {
+			si.byNumber[number] = make(keySet)
+			i, _ := slices.BinarySearch(si.numbers, number)
+			si.numbers = slices.Insert(si.numbers, i, number)
+		}
+		si.byNumber[number][key] = struct{}{}
+	}
+
+	title := strings.ToLower(key.RFCTitle)
+	if _, ok := si.byTitle[title]; !ok {
+		si.byTitle[title] = make(keySet)
+		i, _ := slices.BinarySearch(si.titles, title)
+		si.titles = slices.Insert(si.titles, i, title)
+		for _, trigram := range trigramsOf(title) {
+			if si.trigrams[trigram] == nil {
+				si.trigrams[trigram] = make(map[string]struct{})
+			}
+			si.trigrams[trigram][title] = struct{}{}
+		}
+	}
+	si.byTitle[title][key] = struct{}{}
+}
+
+// remove drops an RFC advertised by a peer from the indexes
+func (si *searchIndex) remove(key rfcKey) {
+	if number, err := strconv.Atoi(key.RFCNumber); err == nil {
+		if keys, ok := si.byNumber[number]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(si.byNumber, number)
+				if i, found := slices.BinarySearch(si.numbers, number); found {
+					si.numbers = slices.Delete(si.numbers, i, i+1)
+				}
+			}
+		}
+	}
+
+	title := strings.ToLower(key.RFCTitle)
+	keys, ok := si.byTitle[title]
+	if !ok {
+		return
+	}
+	delete(keys, key)
+	if len(keys) > 0 {
+		return
+	}
+	delete(si.byTitle, title)
+	if i, found := slices.BinarySearch(si.titles, title); found {
+		si.titles = slices.Delete(si.titles, i, i+1)
+	}
+	for _, trigram := range trigramsOf(title) {
+		delete(si.trigrams[trigram], title)
+		if len(si.trigrams[trigram]) == 0 {
+			delete(si.trigrams, trigram)
+		}
+	}
+}
+
+// search returns the RFCs matching every part of the query
+func (si *searchIndex) search(query SearchQuery) keySet {
+	var result keySet
+	if query.HasNumbers {
+		result = si.numberRange(query.FirstNumber, query.LastNumber)
+	}
+	if query.Title == "" {
+		return result
+	}
+
+	byTitle := make(keySet)
+	for _, title := range si.matchingTitles(strings.ToLower(query.Title), query.TitleMatch) {
+		for key := range si.byTitle[title] {
+			if result == nil {
+				byTitle[key] = struct{}{}
+			} else if _, ok := result[key]; ok {
+				byTitle[key] = struct{}{}
+			}
+		}
+	}
+	return byTitle
+}
+
+// numberRange returns the RFCs numbered from first to last, both included
+func (si *searchIndex) numberRange(first, last int) keySet {
+	keys := make(keySet)
+	start, _ := slices.BinarySearch(si.numbers, first)
+	for _, number := range si.numbers[start:] {
+		if number > last {
+			break
+		}
+		for key := range si.byNumber[number] {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// matchingTitles returns the indexed titles matching a lowercased title
+func (si *searchIndex) matchingTitles(title, match string) []string {
+	if match == TitleMatchPrefix {
+		start, _ := slices.BinarySearch(si.titles, title)
+		end := start
+		for end < len(si.titles) && strings.HasPrefix(si.titles[end], title) {
+			end++
+		}
+		return si.titles[start:end]
+	}
+
+	// Fragments too short to have a trigram are looked for in every distinct title
+	candidates := si.titles
+	if len(title) >= trigramLength {
+		candidates = si.trigramCandidates(title)
+	}
+
+	matches := []string{}
+	for _, candidate := range candidates {
+		if strings.Contains(candidate, title) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// trigramCandidates returns the titles holding every trigram of a fragment, the fragment itself may still be missing
+func (si *searchIndex) trigramCandidates(fragment string) []string {
+	var candidates map[string]struct{}
+	for _, trigram := range trigramsOf(fragment) {
+		titles := si.trigrams[trigram]
+		if len(titles) == 0 {
+			return nil
+		}
+		if candidates == nil || len(titles) < len(candidates) {
+			// Intersect starting from the smallest posting list seen so far
+			next := make(map[string]struct{})
+			for title := range titles {
+				if candidates == nil {
+					next[title] = struct{}{}
+				} else if _, ok := candidates[title]; ok {
+					next[title] = struct{}{}
+				}
+			}
+			candidates = next
+			continue
+		}
+		for title := range candidates {
+			if _, ok := titles[title]; !ok {
+				delete(candidates, title)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(candidates))
+	for title := range candidates {
+		result = append(result, title)
+	}
+	return result
+}
+
+// trigramsOf returns the distinct fragments of trigramLength bytes of a title
+func trigramsOf(title string) []string {
+	trigrams := []string{}
+	for i := 0; i+trigramLength <= len(title); i++ {
+		trigram := title[i : i+trigramLength]
+		if !slices.Contains(trigrams, trigram) {
+			trigrams = append(trigrams, trigram)
+		}
+	}
+	return trigrams
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// searchTitles are the titles of the RFCs every search test starts from, RFC i+1 has title i
+var searchTitles = []string{
+	"Hypertext Transfer Protocol",
+	"HTTP Semantics",
+	"The TLS Protocol",
+	"Internet Protocol",
+	"Transmission Control Protocol",
+	"IP over Avian Carriers",
+	"a",
+}
+
+// newSearchRegistry builds a registry advertising searchTitles, the first two held by two peers
+func newSearchRegistry() *rfcRegistry {
+	rr := newRFCRegistry()
+	rr.AddPeer("peer-0", "10.0.0.1:40000", "50000")
+	rr.AddPeer("peer-1", "10.0.0.2:40000", "50000")
+	for i, title := range searchTitles {
+		number := strconv.Itoa(i + 1)
+		rr.AddRFC("peer-0", number, title, "", "")
+		if i < 2 {
+			rr.AddRFC("peer-1", number, title, "", "")
+		}
+	}
+	return rr
+}
+
+// scanSearch answers a SEARCH by checking every RFC of the registry against the query
+func scanSearch(rr *rfcRegistry, query SearchQuery) []string {
+	found := []string{}
+	for _, entry := range rr.Entries() {
+		if query.matches(entry.RFCNumber, entry.RFCTitle) {
+			found = append(found, entry.PeerID+" "+entry.RFCNumber)
+		}
+	}
+	slices.Sort(found)
+	return found
+}
+
+// search runs a SEARCH through the indexes
+func search(rr *rfcRegistry, query SearchQuery) []string {
+	found := []string{}
+	for _, entry := range rr.Search(query) {
+		found = append(found, entry.PeerID+" "+entry.RFCNumber)
+	}
+	slices.Sort(found)
+	return found
+}
+
+func TestSearchTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string
+	}{
+		{name: "substring", query: SearchQuery{Title: "protocol"}, want: []string{"peer-0 1", "peer-0 3", "peer-0 4", "peer-0 5", "peer-1 1"}},
+		{name: "upper case query", query: SearchQuery{Title: "PROTOCOL"}, want: []string{"peer-0 1", "peer-0 3", "peer-0 4", "peer-0 5", "peer-1 1"}},
+		{name: "mixed case query", query: SearchQuery{Title: "hTtP"}, want: []string{"peer-0 2", "peer-1 2"}},
+		{name: "across words", query: SearchQuery{Title: "tls pro"}, want: []string{"peer-0 3"}},
+		{name: "trigrams present but not in a row", query: SearchQuery{Title: "protocol internet"}, want: []string{}},
+		{name: "no match", query: SearchQuery{Title: "quic"}, want: []string{}},
+		{name: "two characters", query: SearchQuery{Title: "ip"}, want: []string{"peer-0 6"}},
+		{name: "two upper case characters", query: SearchQuery{Title: "IP"}, want: []string{"peer-0 6"}},
+		{name: "one character", query: SearchQuery{Title: "A"}, want: []string{"peer-0 1", "peer-0 2", "peer-0 5", "peer-0 6", "peer-0 7", "peer-1 1", "peer-1 2"}},
+		{name: "prefix", query: SearchQuery{Title: "the", TitleMatch: TitleMatchPrefix}, want: []string{"peer-0 3"}},
+		{name: "short prefix", query: SearchQuery{Title: "H", TitleMatch: TitleMatchPrefix}, want: []string{"peer-0 1", "peer-0 2", "peer-1 1", "peer-1 2"}},
+		{name: "prefix is not a substring", query: SearchQuery{Title: "protocol", TitleMatch: TitleMatchPrefix}, want: []string{}},
+		{name: "numbers and title", query: SearchQuery{HasNumbers: true, FirstNumber: 2, LastNumber: 4, Title: "PROTO"}, want: []string{"peer-0 3", "peer-0 4"}},
+		{name: "numbers only", query: SearchQuery{HasNumbers: true, FirstNumber: 6, LastNumber: 100}, want: []string{"peer-0 6", "peer-0 7"}},
+	}
+
+	rr := newSearchRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := search(rr, tt.query)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Search(%+v) = %v, want %v", tt.query, got, tt.want)
+			}
+			if scanned := scanSearch(rr, tt.query); !slices.Equal(got, scanned) {
+				t.Errorf("Search(%+v) = %v, a scan of the index finds %v", tt.query, got, scanned)
+			}
+		})
+	}
+}
+
+func TestSearchAfterRemovals(t *testing.T) {
+	rr := newSearchRegistry()
+	query := SearchQuery{Title: "transfer"}
+
+	// The title stays indexed while another peer still advertises it
+	rr.RemoveRFC("peer-0", "1", "")
+	if got, want := search(rr, query), []string{"peer-1 1"}; !slices.Equal(got, want) {
+		t.Errorf("Search after one holder removed the RFC = %v, want %v", got, want)
+	}
+
+	rr.RemoveRFCs("peer-1")
+	if got := search(rr, query); len(got) != 0 {
+		t.Errorf("Search after every holder removed the RFC = %v, want nothing", got)
+	}
+
+	// Nothing of the removed title is left in the indexes
+	removed := strings.ToLower(searchTitles[0])
+	if _, ok := rr.search.byTitle[removed]; ok {
+		t.Errorf("title %q still indexed", removed)
+	}
+	if slices.Contains(rr.search.titles, removed) {
+		t.Errorf("title %q still in the sorted titles", removed)
+	}
+	for trigram, titles := range rr.search.trigrams {
+		if _, ok := titles[removed]; ok {
+			t.Errorf("title %q still indexed under trigram %q", removed, trigram)
+		}
+		if len(titles) == 0 {
+			t.Errorf("trigram %q left without titles", trigram)
+		}
+	}
+	if _, ok := rr.search.trigrams["ext"]; ok {
+		t.Error("trigram only found in the removed title still indexed")
+	}
+
+	// Trigrams shared with titles still advertised keep working
+	if got, want := search(rr, SearchQuery{Title: "protocol"}), []string{"peer-0 3", "peer-0 4", "peer-0 5"}; !slices.Equal(got, want) {
+		t.Errorf("Search for a shared fragment = %v, want %v", got, want)
+	}
+	if got, want := search(rr, SearchQuery{HasNumbers: true, FirstNumber: 1, LastNumber: 2}), []string{"peer-0 2"}; !slices.Equal(got, want) {
+		t.Errorf("Search by numbers after removals = %v, want %v", got, want)
+	}
+
+	// A peer without an upload port is left out even while its RFCs are still indexed
+	rr.RemovePeer("peer-0")
+	if got := search(rr, SearchQuery{Title: "protocol"}); len(got) != 0 {
+		t.Errorf("Search after the peer was removed = %v, want nothing", got)
+	}
+}
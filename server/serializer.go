@@ -13,3 +13,12 @@ func SerializeServerResponse(serverResponse data.ServerResponse) ([]byte, error)
 	}
 	return jsonData, nil
 }
+
+// SerializeNotificationStruct converts NotificationStruct into a JSON byte array
+func SerializeNotificationStruct(notification data.NotificationStruct) ([]byte, error) {
+	jsonData, err := json.Marshal(notification)
+	if err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
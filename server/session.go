@@ -0,0 +1,66 @@
+// This file assigns every connected peer the session its index entries are bound to
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+)
+
+// peerSession is a connected peer as the server knows it
+type peerSession struct {
+	// ID is assigned by the server when the peer connects, the index entries of the peer are stored under it
+	ID string
+
+	// Address is where the server saw the peer connect from, it is what LOOKUP and LIST report
+	Address string
+
+	// Identity is the name the peer authenticated as, empty until it sends valid credentials
+	Identity string
+
+	// CanPublish is set when the credentials the peer authenticated with allow changing the index
+	CanPublish bool
+
+	// Log tags every message about the session with its peer ID and address
+	Log *slog.Logger
+}
+
+// newPeerSession creates a session with a fresh random peer ID
+func newPeerSession() (*peerSession, error) {
+	b := make([]byte, PeerIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating peer ID: %w", err)
+	}
+	return &peerSession{ID: hex.EncodeToString(b)}, nil
+}
+
+// checkClaimedAddress logs a request claiming to come from somewhere else than the session.
+// The claim is never trusted, entries are always bound to the session that sent them.
+func (s *peerSession) checkClaimedAddress(req *request, claimed string) {
+	if claimed != "" && claimed != s.Address {
+		req.Log.Warn("Peer claimed another address, using the address of the session", "claimed", claimed)
+	}
+}
+
+// authorizePublish tells whether the session may ADD or REMOVE, returning the status to refuse it with otherwise.
+// Without a keys file every session may publish.
+func (s *peerSession) authorizePublish() (int, string, bool) {
+	switch {
+	case authKeys == nil:
+		return 0, "", true
+	case s.Identity == "":
+		return StatusUnauthorized, "Unauthorized", false
+	case !s.CanPublish:
+		return StatusForbidden, "Forbidden", false
+	}
+	return 0, "", true
+}
+
+// authorizeRead tells whether the session may LOOKUP or LIST, returning the status to refuse it with otherwise
+func (s *peerSession) authorizeRead() (int, string, bool) {
+	if authKeys == nil || authKeys.anonymousRead || s.Identity != "" {
+		return 0, "", true
+	}
+	return StatusUnauthorized, "Unauthorized", false
+}
@@ -0,0 +1,93 @@
+// This file defines the pluggable index store behind the ADD/LOOKUP/LIST handlers
+package main
+
+// IndexEntry represents a single RFC advertised by a peer
+type IndexEntry struct {
+	PeerID     string
+	Hostname   string
+	UploadPort string
+	RFCNumber  string
+	RFCTitle   string
+	RFCDigest  string
+
+	// RFCManifest is the encoded manifest signed by the publisher, empty for unsigned RFCs
+	RFCManifest string
+}
+
+// PeerEntry represents a peer known to the index
+type PeerEntry struct {
+	PeerID     string
+	Hostname   string
+	UploadPort string
+
+	// RFCs is the number of RFCs the peer advertises
+	RFCs int
+}
+
+// IndexStore is the storage backend for the peer info and RFC index.
+// Everything is keyed by the peer ID the server assigned to the session of the peer.
+type IndexStore interface {
+	// AddRFC adds an RFC to the index for a given peer, replacing the digest and manifest if it is already there
+	AddRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) error
+	// HasRFC checks if an RFC with the same digest and manifest already exists in the index for a given peer
+	HasRFC(peerID, rfcNumber, rfcTitle, rfcDigest, rfcManifest string) bool
+	// AddPeer records the address a peer connected from and its upload port
+	AddPeer(peerID, hostname, uploadPort string) error
+	// HasPeer checks if a peer is known to the index
+	HasPeer(peerID string) bool
+	// RemovePeer removes the peer info of a peer
+	RemovePeer(peerID string) error
+	// RemoveRFC removes an RFC advertised by a peer, any title matches an empty title.
+	// It returns the titles that were removed.
+	RemoveRFC(peerID, rfcNumber, rfcTitle string) ([]string, error)
+	// RemoveRFCs removes every RFC advertised by a peer
+	RemoveRFCs(peerID string) error
+	// PeerRFCs returns every RFC advertised by a peer, whether its upload port is known or not
+	PeerRFCs(peerID string) []IndexEntry
+	// Entries returns a consistent copy of every RFC whose peer has a known upload port
+	Entries() []IndexEntry
+	// Peers returns a consistent copy of every peer with a known upload port
+	Peers() []PeerEntry
+	// PeerIDs returns every peer with peer info or RFCs, whether its upload port is known or not
+	PeerIDs() []string
+	// EntryCount returns the number of RFCs Entries would return, without copying them
+	EntryCount() int
+	// PeerCount returns the number of peers Peers would return, without copying them
+	PeerCount() int
+	// Lookup returns the RFCs with a number whose peer has a known upload port, any title matches an empty title.
+	// It only visits the RFCs with that number, whatever the size of the index.
+	Lookup(rfcNumber, rfcTitle string) []IndexEntry
+	// List returns up to limit RFCs whose peer has a known upload port, following cursor in RFC number order.
+	// It also returns the cursor of the last RFC returned and whether there are more RFCs after it.
+	List(cursor ListCursor, limit int) ([]IndexEntry, ListCursor, bool)
+	// Search returns the RFCs matching a query whose peer has a known upload port, ordered by RFC number
+	Search(query SearchQuery) []IndexEntry
+	// Close flushes and releases any resources held by the store
+	Close() error
+}
+
+// memoryStore keeps the index in memory only, it is lost on restart
+type memoryStore struct {
+	*rfcRegistry
+}
+
+// newMemoryStore creates an empty in-memory index store
+func newMemoryStore() *memoryStore {
+	return &memoryStore{rfcRegistry: newRFCRegistry()}
+}
+
+func (ms *memoryStore) Close() error {
+	return nil
+}
+
+// openIndexStore opens the index store selected by the configuration
+func openIndexStore(kind, dir string) (IndexStore, error) {
+	switch kind {
+	case IndexStoreMemory:
+		serverLog.Warn("Using in-memory index store, the index will not survive restarts")
+		return newMemoryStore(), nil
+	default:
+		serverLog.Info("Using on-disk index store", "dir", dir)
+		return openFileStore(dir)
+	}
+}
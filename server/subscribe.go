@@ -0,0 +1,121 @@
+// This file pushes ADDED and REMOVED notifications to the peers that subscribed to them
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	common_helpers "P2P/common-helpers"
+	"P2P/common-helpers/codec"
+	"P2P/common-helpers/data"
+)
+
+// subscriber is a session that subscribed to changes of the index
+type subscriber struct {
+	conn    *codec.Conn
+	log     *slog.Logger
+	queries []SearchQuery
+
+	// events is drained by a goroutine of its own, so a slow peer never holds up the handler that caused the event
+	events chan data.NotificationStruct
+}
+
+// subscriberTable holds the subscriptions of every session
+type subscriberTable struct {
+	mu        sync.Mutex
+	bySession map[string]*subscriber
+}
+
+// newSubscriberTable creates a table without subscriptions
+func newSubscriberTable() *subscriberTable {
+	return &subscriberTable{bySession: make(map[string]*subscriber)}
+}
+
+// subscribe adds a query to the subscriptions of a session and returns how many it has.
+// Notifications start being delivered with the first subscription of the session.
+func (st *subscriberTable) subscribe(session *peerSession, conn *codec.Conn, query SearchQuery) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.bySession[session.ID]
+	if !ok {
+		s = &subscriber{
+			conn:   conn,
+			log:    session.Log,
+			events: make(chan data.NotificationStruct, SubscriberQueueLength),
+		}
+		st.bySession[session.ID] = s
+		go s.deliver()
+	}
+	s.queries = append(s.queries, query)
+	return len(s.queries)
+}
+
+// release drops the subscriptions of a session that ended
+func (st *subscriberTable) release(peerID string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if s, ok := st.bySession[peerID]; ok {
+		close(s.events)
+		delete(st.bySession, peerID)
+	}
+}
+
+// notify queues an event about an RFC for every session with a subscription matching it
+func (st *subscriberTable) notify(event string, entry IndexEntry, requestID string) {
+	notification := data.NotificationStruct{
+		Event:                    event,
+		RFCNumber:                entry.RFCNumber,
+		RFCTitle:                 entry.RFCTitle,
+		ClientIP:                 entry.Hostname,
+		ClientUploadPort:         entry.UploadPort,
+		RFCDigest:                entry.RFCDigest,
+		RFCManifest:              entry.RFCManifest,
+		ServerApplicationVersion: ApplicationVersion,
+		RequestID:                requestID,
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, s := range st.bySession {
+		if !s.wants(entry) {
+			continue
+		}
+		select {
+		case s.events <- notification:
+			notifications.Inc(event)
+		default:
+			droppedEvents.Inc()
+			s.log.Warn("Notification queue full, dropping event", "event", event, "rfc", entry.RFCNumber)
+		}
+	}
+}
+
+// wants checks if any subscription of the subscriber matches an RFC
+func (s *subscriber) wants(entry IndexEntry) bool {
+	for _, query := range s.queries {
+		if query.matches(entry.RFCNumber, entry.RFCTitle) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver writes the queued notifications to the peer until its subscriptions are released
+func (s *subscriber) deliver() {
+	for notification := range s.events {
+		serialized, err := SerializeNotificationStruct(notification)
+		if err != nil {
+			s.log.Error("Error serializing notification", "error", err)
+			continue
+		}
+		if err := s.conn.WriteFrame(common_helpers.NotificationIndex, serialized, nil); err != nil {
+			// The session ends with the connection, releasing the subscriptions
+			s.log.Warn("Error sending notification", "error", err)
+			return
+		}
+		s.log.Debug("Notification sent", "event", notification.Event, "rfc", notification.RFCNumber)
+	}
+}